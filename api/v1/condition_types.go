@@ -0,0 +1,55 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition types reported by PagerdutyServices and PagerdutyRulesets
+const (
+	// ConditionReady is true when everything else has been synced to pagerduty
+	ConditionReady = "Ready"
+	// ConditionEscalationPolicyResolved is true when the service's escalation policy exists in pagerduty
+	ConditionEscalationPolicyResolved = "EscalationPolicyResolved"
+	// ConditionServiceSynced is true when the pagerduty service matches the spec
+	ConditionServiceSynced = "ServiceSynced"
+	// ConditionRuleSynced is true when the ruleset rules match the spec
+	ConditionRuleSynced = "RuleSynced"
+	// ConditionDeleting is true while the pagerduty resources are being cleaned up
+	ConditionDeleting = "Deleting"
+)
+
+// Condition is a single aspect of a resource's state. It mirrors the
+// upstream metav1.Condition, which our version of apimachinery doesn't have yet.
+type Condition struct {
+	// Type of condition in CamelCase, e.g. Ready
+	Type string `json:"type"`
+	// Status is one of True, False or Unknown
+	// +kubebuilder:validation:Enum=True;False;Unknown
+	Status metav1.ConditionStatus `json:"status"`
+	// ObservedGeneration is the metadata.generation the condition was set for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastTransitionTime is when the condition last changed its status
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	// Reason is a CamelCase reason for the condition's last transition
+	Reason string `json:"reason"`
+	// Message is a human readable explanation
+	// +optional
+	Message string `json:"message,omitempty"`
+}
@@ -28,8 +28,19 @@ type PagerdutyRulesetSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Foo is an example field of PagerdutyRuleset. Edit PagerdutyRuleset_types.go to remove/update
+	// CatchallService is the name of a pagerduty service that receives events no rule matched.
+	// When neither it nor catchallServiceRef is set, unmatched events are suppressed.
 	CatchallService string `json:"catchallService,omitempty"`
+
+	// CatchallServiceRef points the catch-all rule at the service of a PagerdutyService resource instead
+	CatchallServiceRef *PagerdutyServiceRef `json:"catchallServiceRef,omitempty"`
+}
+
+// PagerdutyServiceRef refers to a PagerdutyService resource
+type PagerdutyServiceRef struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the referring resource
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // PagerdutyRulesetStatus defines the observed state of PagerdutyRuleset
@@ -38,9 +49,22 @@ type PagerdutyRulesetStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	RulesetID string `json:"rulesetID,omitempty"`
 	Created   bool   `json:"created"`
+
+	CatchallServiceID string `json:"catchallServiceID,omitempty"`
+	CatchallRuleID    string `json:"catchallRuleID,omitempty"`
+
+	// ObservedGeneration is the metadata.generation last reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready, RuleSynced (for the catch-all rule) and Deleting
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Ruleset",type=string,JSONPath=`.status.rulesetID`
 
 // PagerdutyRuleset is the Schema for the pagerdutyrulesets API
 type PagerdutyRuleset struct {
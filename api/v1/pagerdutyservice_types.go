@@ -23,12 +23,106 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// LabelMatchMode controls how strictly a label pair is matched against an alert
+// +kubebuilder:validation:Enum=Contains;Exact
+type LabelMatchMode string
+
+const (
+	// LabelMatchContains does a substring match on "key = value", so "app = foo"
+	// also matches "app = foobar" and "myapp = foo"
+	LabelMatchContains LabelMatchMode = "Contains"
+	// LabelMatchExact only matches the complete "key = value" label line
+	LabelMatchExact LabelMatchMode = "Exact"
+)
+
+// LabelOperator is the comparison applied to a label
+// +kubebuilder:validation:Enum=Equals;NotEquals;Regex;Exists;NotExists;In;NotIn
+type LabelOperator string
+
+const (
+	LabelOpEquals    LabelOperator = "Equals"
+	LabelOpNotEquals LabelOperator = "NotEquals"
+	// LabelOpRegex matches the whole label value against an RE2 regular expression
+	LabelOpRegex     LabelOperator = "Regex"
+	LabelOpExists    LabelOperator = "Exists"
+	LabelOpNotExists LabelOperator = "NotExists"
+	LabelOpIn        LabelOperator = "In"
+	LabelOpNotIn     LabelOperator = "NotIn"
+)
+
 type LabelSpec struct {
+	// +kubebuilder:validation:MinLength=1
 	Key string `json:"key"`
 
-	Value string `json:"value"`
+	// Operator defaults to Equals
+	// +optional
+	Operator LabelOperator `json:"operator,omitempty"`
+
+	// Value is required by the Equals, NotEquals and Regex operators
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Values is required by the In and NotIn operators
+	// +optional
+	Values []string `json:"values,omitempty"`
+
+	// MatchMode defaults to Contains, for backwards compatibility.
+	// It applies to the Equals and NotEquals operators on the Alertmanager firing text;
+	// In and NotIn always match whole values, and other event fields are always compared exactly.
+	// +optional
+	MatchMode LabelMatchMode `json:"matchMode,omitempty"`
+
+	// Path overrides the event field this label is matched against. "{key}" is replaced by the label key.
+	// +optional
+	Path string `json:"path,omitempty"`
 }
 
+// SourceProfile describes where an event source puts its labels
+// +kubebuilder:validation:Enum=alertmanager;events-v2-custom-details;grafana
+type SourceProfile string
+
+const (
+	// SourceProfileAlertmanager matches labels in the firing text Alertmanager renders into details.firing
+	SourceProfileAlertmanager SourceProfile = "alertmanager"
+	// SourceProfileCustomDetails matches labels sent as Events API v2 custom_details, at details.<key>
+	SourceProfileCustomDetails SourceProfile = "events-v2-custom-details"
+	// SourceProfileGrafana matches the labels Grafana sends at details.labels.<key>
+	SourceProfileGrafana SourceProfile = "grafana"
+)
+
+// DeletionPolicy decides what happens to the pagerduty service when its PagerdutyService is deleted
+// +kubebuilder:validation:Enum=Delete;Retain;Disable
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the service, along with its incident history
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain only deletes the routing rules, leaving the service as it is
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDisable deletes the routing rules, and disables the service and marks its name as archived
+	DeletionPolicyDisable DeletionPolicy = "Disable"
+)
+
+// OpenIncidentsPolicy decides what happens when a service about to be deleted has open incidents
+// +kubebuilder:validation:Enum=Block;Resolve;Ignore
+type OpenIncidentsPolicy string
+
+const (
+	// OpenIncidentsBlock keeps the service until its incidents are resolved
+	OpenIncidentsBlock OpenIncidentsPolicy = "Block"
+	// OpenIncidentsResolve resolves the incidents with a note, and then deletes the service
+	OpenIncidentsResolve OpenIncidentsPolicy = "Resolve"
+	// OpenIncidentsIgnore deletes the service regardless
+	OpenIncidentsIgnore OpenIncidentsPolicy = "Ignore"
+)
+
+// IgnoreOpenIncidentsAnnotation set to "true" lets a service be deleted despite its open incidents
+const IgnoreOpenIncidentsAnnotation = "pagerduty.core.strateos.com/ignore-open-incidents"
+
+// ForceRemoveFinalizerAnnotation set to "true" on a PagerdutyService or PagerdutyRuleset lets it be deleted
+// when cleaning up in pagerduty fails. The objects left behind are recorded in the operator's orphan ledger.
+const ForceRemoveFinalizerAnnotation = "pagerduty.core.strateos.com/force-remove-finalizer"
+
 // EscalationPolicySecretSpec allows you to retrieve the escalation policy from a secret
 // in the same namespace as the PagerdutyService
 type EscalationPolicySecretSpec struct {
@@ -45,8 +139,187 @@ type PagerdutyServiceSpec struct {
 	EscalationPolicy       string                     `json:"escalationPolicy,omitEmpty"`
 	EscalationPolicySecret EscalationPolicySecretSpec `json:"escalationPolicySecret,omitEmpty"`
 
-	// +kubebuilder:validation:MinItems:=1
-	MatchLabels []LabelSpec `json:"matchLabels"`
+	// SourceProfile picks the event fields labels are matched against.
+	// Defaults to the operator's -source-profile flag.
+	// +optional
+	SourceProfile SourceProfile `json:"sourceProfile,omitempty"`
+
+	// FieldPath overrides the source profile's event field for all labels.
+	// "{key}" is replaced by the label key.
+	// +optional
+	FieldPath string `json:"fieldPath,omitempty"`
+
+	// The top level matchLabels and matchAny are the first selector group
+	SelectorSpec `json:",inline"`
+
+	// Selectors are alternative groups, each routing to this service with its own rule
+	// +optional
+	Selectors []SelectorSpec `json:"selectors,omitempty"`
+
+	// Actions are applied by the routing rules, in addition to routing the alert to the service
+	// +optional
+	Actions *RuleActionsSpec `json:"actions,omitempty"`
+
+	// TimeFrame limits when the routing rules are active
+	// +optional
+	TimeFrame *TimeFrameSpec `json:"timeFrame,omitempty"`
+
+	// RulePriority orders this service's rules within the ruleset. Rules of services with a higher
+	// priority are evaluated first, so more specific routes should get higher priorities. Defaults to 0.
+	// +optional
+	RulePriority int `json:"rulePriority,omitempty"`
+
+	// ServiceName is used verbatim as the pagerduty service name, instead of a template's name
+	// +kubebuilder:validation:MaxLength=255
+	// +optional
+	ServiceName string `json:"serviceName,omitempty"`
+
+	// ServiceNamePrefixed prepends the operator's -service-prefix to ServiceName
+	// +optional
+	ServiceNamePrefixed bool `json:"serviceNamePrefixed,omitempty"`
+
+	// ServiceNameTemplate is a Go template for the pagerduty service name, overriding the
+	// operator's -service-name-template. It can use .Prefix, .Cluster, .Namespace and .Name,
+	// e.g. "{{.Prefix}}-{{.Cluster}}-{{.Namespace}}-{{.Name}}".
+	// +optional
+	ServiceNameTemplate string `json:"serviceNameTemplate,omitempty"`
+
+	// Adopt takes over an existing pagerduty service instead of creating a new one
+	// +optional
+	Adopt *ServiceAdoptionSpec `json:"adopt,omitempty"`
+
+	// DeletionPolicy decides what happens to the pagerduty service when this resource is deleted.
+	// Defaults to Retain for adopted services, and to the operator's -deletion-policy otherwise.
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// OpenIncidentsPolicy decides what happens when the service has triggered or acknowledged
+	// incidents as it's deleted. Defaults to the operator's -open-incidents-policy.
+	// +optional
+	OpenIncidentsPolicy OpenIncidentsPolicy `json:"openIncidentsPolicy,omitempty"`
+
+	// RulesetRef points at the PagerdutyRuleset the routing rules are written to.
+	// Without it, the operator's -ruleset flag is used.
+	// +optional
+	RulesetRef *PagerdutyRulesetRef `json:"rulesetRef,omitempty"`
+}
+
+// ServiceAdoptionSpec picks the existing pagerduty service to adopt. Adopted services are
+// updated to match the spec, but aren't deleted with the resource.
+type ServiceAdoptionSpec struct {
+	// ServiceID adopts the service with this ID, which must exist
+	// +optional
+	ServiceID string `json:"serviceID,omitempty"`
+
+	// ByName adopts the service named exactly like the resource's service name.
+	// A new service is created if there is none.
+	// +optional
+	ByName bool `json:"byName,omitempty"`
+}
+
+// PagerdutyRulesetRef refers to a PagerdutyRuleset resource
+type PagerdutyRulesetRef struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the referring resource
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// TimeFrameSpec limits when the routing rules are active.
+// Exactly one of ActiveBetween and ScheduledWeekly must be set.
+type TimeFrameSpec struct {
+	// +optional
+	ActiveBetween *ActiveBetweenSpec `json:"activeBetween,omitempty"`
+
+	// +optional
+	ScheduledWeekly *ScheduledWeeklySpec `json:"scheduledWeekly,omitempty"`
+}
+
+// ActiveBetweenSpec makes the rules active during a single window
+type ActiveBetweenSpec struct {
+	Start metav1.Time `json:"start"`
+	End   metav1.Time `json:"end"`
+}
+
+// +kubebuilder:validation:Enum=Monday;Tuesday;Wednesday;Thursday;Friday;Saturday;Sunday
+type Weekday string
+
+// ScheduledWeeklySpec makes the rules active during a window on some days of every week
+type ScheduledWeeklySpec struct {
+	// +kubebuilder:validation:MinItems=1
+	Weekdays []Weekday `json:"weekdays"`
+
+	// Timezone is an IANA time zone name, e.g. America/Los_Angeles
+	Timezone string `json:"timezone"`
+
+	// StartTime is the local time of day the window opens, as HH:MM
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	StartTime string `json:"startTime"`
+
+	// Duration of the window, e.g. 8h or 90m. At most a week.
+	Duration metav1.Duration `json:"duration"`
+}
+
+// RuleActionsSpec shapes the alerts matched by the service's routing rules
+type RuleActionsSpec struct {
+	// +kubebuilder:validation:Enum=critical;error;warning;info
+	// +optional
+	Severity string `json:"severity,omitempty"`
+
+	// Priority is the name of a pagerduty priority, e.g. P1
+	// +optional
+	Priority string `json:"priority,omitempty"`
+
+	// Annotate adds a note to the resulting incident
+	// +optional
+	Annotate string `json:"annotate,omitempty"`
+
+	// +kubebuilder:validation:Enum=trigger;resolve
+	// +optional
+	EventAction string `json:"eventAction,omitempty"`
+
+	// Suppress stops alerts from creating incidents, optionally only until a threshold is reached
+	// +optional
+	Suppress *SuppressSpec `json:"suppress,omitempty"`
+
+	// +optional
+	Extractions []ExtractionSpec `json:"extractions,omitempty"`
+}
+
+// SuppressSpec suppresses matching alerts. If a threshold is set, an incident is created
+// once ThresholdValue alerts arrive within ThresholdTimeAmount ThresholdTimeUnits.
+type SuppressSpec struct {
+	// +optional
+	ThresholdValue int `json:"thresholdValue,omitempty"`
+
+	// +kubebuilder:validation:Enum=seconds;minutes;hours
+	// +optional
+	ThresholdTimeUnit string `json:"thresholdTimeUnit,omitempty"`
+
+	// +optional
+	ThresholdTimeAmount int `json:"thresholdTimeAmount,omitempty"`
+}
+
+// ExtractionSpec rewrites an event field from the first capture group of Regex applied to Source
+type ExtractionSpec struct {
+	// Target defaults to summary
+	// +optional
+	Target string `json:"target,omitempty"`
+
+	Source string `json:"source"`
+
+	Regex string `json:"regex"`
+}
+
+// SelectorSpec is a group of label selectors that routes alerts to the service.
+// When both lists are set, an alert must match all of MatchLabels and at least one of MatchAny.
+type SelectorSpec struct {
+	// MatchLabels must all match
+	// +optional
+	MatchLabels []LabelSpec `json:"matchLabels,omitempty"`
+
+	// At least one of MatchAny must match
+	// +optional
+	MatchAny []LabelSpec `json:"matchAny,omitempty"`
 }
 
 // PagerdutyServiceStatus defines the observed state of PagerdutyService
@@ -57,11 +330,49 @@ type PagerdutyServiceStatus struct {
 	// +optional
 	ServiceID   string `json:"pagerdutyServiceID,omitempty"`
 	ServiceName string `json:"pagerdutyServiceName,omitempty"`
-	RuleID      string `json:"ruleID,omitempty"`
-	Status      string `json:"status,omitempty"`
+	// BaseServiceName is the name the service name template rendered. ServiceName differs
+	// when that name was already taken.
+	BaseServiceName string `json:"baseServiceName,omitempty"`
+	// RuleID is the first of RuleIDs, kept for compatibility
+	RuleID  string   `json:"ruleID,omitempty"`
+	RuleIDs []string `json:"ruleIDs,omitempty"`
+	Status  string   `json:"status,omitempty"`
+
+	// TimeFrame describes when the routing rules are active, if they are limited
+	TimeFrame string `json:"timeFrame,omitempty"`
+
+	// RulesetID is the ruleset holding the routing rules
+	RulesetID string `json:"rulesetID,omitempty"`
+
+	// Adopted is true when the service existed before the operator took it over, rather than being created by it
+	// +optional
+	Adopted bool `json:"adopted,omitempty"`
+
+	// Creating is "service" or "rule" while one is being created, until its ID is saved.
+	// A reconcile that finds it set looks for what was created before creating another.
+	// +optional
+	Creating string `json:"creating,omitempty"`
+
+	// Recreations counts the services and rules that were deleted in pagerduty and recreated by the operator
+	// +optional
+	Recreations int `json:"recreations,omitempty"`
+
+	// LastDriftDetected is when changes made to the service or its rules outside the operator were last corrected
+	// +optional
+	LastDriftDetected *metav1.Time `json:"lastDriftDetected,omitempty"`
+
+	// ObservedGeneration is the metadata.generation last reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready, EscalationPolicyResolved, ServiceSynced, RuleSynced and Deleting
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.status.pagerdutyServiceID`
 
 // PagerdutyService is the Schema for the pagerdutyservices API
 type PagerdutyService struct {
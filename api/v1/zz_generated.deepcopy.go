@@ -24,6 +24,39 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveBetweenSpec) DeepCopyInto(out *ActiveBetweenSpec) {
+	*out = *in
+	in.Start.DeepCopyInto(&out.Start)
+	in.End.DeepCopyInto(&out.End)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveBetweenSpec.
+func (in *ActiveBetweenSpec) DeepCopy() *ActiveBetweenSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveBetweenSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Condition) DeepCopyInto(out *Condition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Condition.
+func (in *Condition) DeepCopy() *Condition {
+	if in == nil {
+		return nil
+	}
+	out := new(Condition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EscalationPolicySecretSpec) DeepCopyInto(out *EscalationPolicySecretSpec) {
 	*out = *in
@@ -39,9 +72,29 @@ func (in *EscalationPolicySecretSpec) DeepCopy() *EscalationPolicySecretSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExtractionSpec) DeepCopyInto(out *ExtractionSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExtractionSpec.
+func (in *ExtractionSpec) DeepCopy() *ExtractionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ExtractionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LabelSpec) DeepCopyInto(out *LabelSpec) {
 	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabelSpec.
@@ -59,8 +112,8 @@ func (in *PagerdutyRuleset) DeepCopyInto(out *PagerdutyRuleset) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PagerdutyRuleset.
@@ -113,9 +166,29 @@ func (in *PagerdutyRulesetList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PagerdutyRulesetRef) DeepCopyInto(out *PagerdutyRulesetRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PagerdutyRulesetRef.
+func (in *PagerdutyRulesetRef) DeepCopy() *PagerdutyRulesetRef {
+	if in == nil {
+		return nil
+	}
+	out := new(PagerdutyRulesetRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PagerdutyRulesetSpec) DeepCopyInto(out *PagerdutyRulesetSpec) {
 	*out = *in
+	if in.CatchallServiceRef != nil {
+		in, out := &in.CatchallServiceRef, &out.CatchallServiceRef
+		*out = new(PagerdutyServiceRef)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PagerdutyRulesetSpec.
@@ -131,6 +204,13 @@ func (in *PagerdutyRulesetSpec) DeepCopy() *PagerdutyRulesetSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PagerdutyRulesetStatus) DeepCopyInto(out *PagerdutyRulesetStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PagerdutyRulesetStatus.
@@ -149,7 +229,7 @@ func (in *PagerdutyService) DeepCopyInto(out *PagerdutyService) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PagerdutyService.
@@ -202,14 +282,52 @@ func (in *PagerdutyServiceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PagerdutyServiceRef) DeepCopyInto(out *PagerdutyServiceRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PagerdutyServiceRef.
+func (in *PagerdutyServiceRef) DeepCopy() *PagerdutyServiceRef {
+	if in == nil {
+		return nil
+	}
+	out := new(PagerdutyServiceRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PagerdutyServiceSpec) DeepCopyInto(out *PagerdutyServiceSpec) {
 	*out = *in
 	out.EscalationPolicySecret = in.EscalationPolicySecret
-	if in.MatchLabels != nil {
-		in, out := &in.MatchLabels, &out.MatchLabels
-		*out = make([]LabelSpec, len(*in))
-		copy(*out, *in)
+	in.SelectorSpec.DeepCopyInto(&out.SelectorSpec)
+	if in.Selectors != nil {
+		in, out := &in.Selectors, &out.Selectors
+		*out = make([]SelectorSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Actions != nil {
+		in, out := &in.Actions, &out.Actions
+		*out = new(RuleActionsSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TimeFrame != nil {
+		in, out := &in.TimeFrame, &out.TimeFrame
+		*out = new(TimeFrameSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Adopt != nil {
+		in, out := &in.Adopt, &out.Adopt
+		*out = new(ServiceAdoptionSpec)
+		**out = **in
+	}
+	if in.RulesetRef != nil {
+		in, out := &in.RulesetRef, &out.RulesetRef
+		*out = new(PagerdutyRulesetRef)
+		**out = **in
 	}
 }
 
@@ -226,6 +344,22 @@ func (in *PagerdutyServiceSpec) DeepCopy() *PagerdutyServiceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PagerdutyServiceStatus) DeepCopyInto(out *PagerdutyServiceStatus) {
 	*out = *in
+	if in.RuleIDs != nil {
+		in, out := &in.RuleIDs, &out.RuleIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastDriftDetected != nil {
+		in, out := &in.LastDriftDetected, &out.LastDriftDetected
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PagerdutyServiceStatus.
@@ -237,3 +371,133 @@ func (in *PagerdutyServiceStatus) DeepCopy() *PagerdutyServiceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuleActionsSpec) DeepCopyInto(out *RuleActionsSpec) {
+	*out = *in
+	if in.Suppress != nil {
+		in, out := &in.Suppress, &out.Suppress
+		*out = new(SuppressSpec)
+		**out = **in
+	}
+	if in.Extractions != nil {
+		in, out := &in.Extractions, &out.Extractions
+		*out = make([]ExtractionSpec, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleActionsSpec.
+func (in *RuleActionsSpec) DeepCopy() *RuleActionsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RuleActionsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduledWeeklySpec) DeepCopyInto(out *ScheduledWeeklySpec) {
+	*out = *in
+	if in.Weekdays != nil {
+		in, out := &in.Weekdays, &out.Weekdays
+		*out = make([]Weekday, len(*in))
+		copy(*out, *in)
+	}
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduledWeeklySpec.
+func (in *ScheduledWeeklySpec) DeepCopy() *ScheduledWeeklySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduledWeeklySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SelectorSpec) DeepCopyInto(out *SelectorSpec) {
+	*out = *in
+	if in.MatchLabels != nil {
+		in, out := &in.MatchLabels, &out.MatchLabels
+		*out = make([]LabelSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MatchAny != nil {
+		in, out := &in.MatchAny, &out.MatchAny
+		*out = make([]LabelSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SelectorSpec.
+func (in *SelectorSpec) DeepCopy() *SelectorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SelectorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceAdoptionSpec) DeepCopyInto(out *ServiceAdoptionSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceAdoptionSpec.
+func (in *ServiceAdoptionSpec) DeepCopy() *ServiceAdoptionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceAdoptionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SuppressSpec) DeepCopyInto(out *SuppressSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SuppressSpec.
+func (in *SuppressSpec) DeepCopy() *SuppressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SuppressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TimeFrameSpec) DeepCopyInto(out *TimeFrameSpec) {
+	*out = *in
+	if in.ActiveBetween != nil {
+		in, out := &in.ActiveBetween, &out.ActiveBetween
+		*out = new(ActiveBetweenSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScheduledWeekly != nil {
+		in, out := &in.ScheduledWeekly, &out.ScheduledWeekly
+		*out = new(ScheduledWeeklySpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TimeFrameSpec.
+func (in *TimeFrameSpec) DeepCopy() *TimeFrameSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TimeFrameSpec)
+	in.DeepCopyInto(out)
+	return out
+}
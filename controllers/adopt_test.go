@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+// TestAdoptService ensures existing services are taken over instead of duplicated, and outlive the resource
+func TestAdoptService(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Spec: v1.PagerdutyServiceSpec{
+			Description:      "managed now",
+			EscalationPolicy: "EP1",
+			SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+			Adopt:            &v1.ServiceAdoptionSpec{ByName: true},
+		},
+	}
+	handmade := &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "HANDMADE"}, Name: "api", Description: "made by hand"}
+	pdClient := &PagerdutyClientMock{service: handmade}
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var reconciled v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	g.Expect(reconciled.Status.ServiceID).To(Equal("HANDMADE"))
+	g.Expect(reconciled.Status.Adopted).To(BeTrue())
+	g.Expect(withoutOwnerMarker(pdClient.service.Description)).To(Equal("managed now"))
+
+	// only the routing rule goes away with the resource
+	g.Expect(r.destroyPagerdutyResources(&reconciled)).To(Succeed())
+	g.Expect(pdClient.service).ToNot(BeNil())
+	g.Expect(pdClient.rulesetRule).To(BeNil())
+}
+
+// TestAdoptMissingService checks what happens when there's nothing to adopt
+func TestAdoptMissingService(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Spec: v1.PagerdutyServiceSpec{
+			EscalationPolicy: "EP1",
+			SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+			Adopt:            &v1.ServiceAdoptionSpec{ByName: true},
+		},
+	}
+	byID := kubeService.DeepCopy()
+	byID.Spec.Adopt = &v1.ServiceAdoptionSpec{ServiceID: "GONE"}
+	pdClient := &PagerdutyClientMock{}
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+
+	// no service by that name, so one is created
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	var reconciled v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	g.Expect(reconciled.Status.ServiceID).To(Equal(testID))
+	g.Expect(reconciled.Status.Adopted).To(BeFalse())
+
+	// a missing ID is an error
+	r = newTestReconciler(byID)
+	r.PdClient = &PagerdutyClientMock{deletedIDs: map[string]bool{"GONE": true}}
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).To(HaveOccurred())
+	var failed v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &failed)).To(Succeed())
+	g.Expect(failed.Status.ServiceID).To(BeEmpty())
+	g.Expect(FindCondition(failed.Status.Conditions, v1.ConditionServiceSynced).Reason).To(Equal("NotFound"))
+}
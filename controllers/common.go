@@ -1,7 +1,13 @@
 package controllers
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
 )
 
 // EnsureFinalizerExists idempotently adds a finalizer to resource metadata
@@ -16,6 +22,64 @@ func EnsureFinalizerRemoved(meta *metav1.ObjectMeta, finalizer string) {
 	meta.SetFinalizers(removeStringFromSlice(meta.Finalizers, finalizer))
 }
 
+// SetCondition adds or updates a status condition. The transition time only moves when the status changes.
+func SetCondition(conditions *[]v1.Condition, conditionType string, status metav1.ConditionStatus, reason string, message string, generation int64) {
+	condition := FindCondition(*conditions, conditionType)
+	if condition == nil {
+		*conditions = append(*conditions, v1.Condition{Type: conditionType})
+		condition = &(*conditions)[len(*conditions)-1]
+	}
+	if condition.Status != status || condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Status = status
+	condition.Reason = reason
+	condition.Message = message
+	condition.ObservedGeneration = generation
+}
+
+// SetConditionFromError sets a condition to True when err is nil, or to False with the
+// given reason and the error as its message otherwise
+func SetConditionFromError(conditions *[]v1.Condition, conditionType string, err error, failureReason string, generation int64) {
+	if err == nil {
+		SetCondition(conditions, conditionType, metav1.ConditionTrue, "Synced", "", generation)
+	} else {
+		SetCondition(conditions, conditionType, metav1.ConditionFalse, failureReason, err.Error(), generation)
+	}
+}
+
+// FindCondition returns the condition of the given type, or nil
+func FindCondition(conditions []v1.Condition, conditionType string) *v1.Condition {
+	for idx := range conditions {
+		if conditions[idx].Type == conditionType {
+			return &conditions[idx]
+		}
+	}
+	return nil
+}
+
+func isConditionTrue(conditions []v1.Condition, conditionType string) bool {
+	condition := FindCondition(conditions, conditionType)
+	return condition != nil && condition.Status == metav1.ConditionTrue
+}
+
+// SetReadyCondition sets Ready to True if all the given conditions are True, or to False
+// with the reason and message of the first one that isn't
+func SetReadyCondition(conditions *[]v1.Condition, generation int64, dependsOn ...string) {
+	for _, conditionType := range dependsOn {
+		condition := FindCondition(*conditions, conditionType)
+		if condition == nil {
+			SetCondition(conditions, v1.ConditionReady, metav1.ConditionUnknown, "Reconciling", conditionType+" has not been checked yet", generation)
+			return
+		}
+		if condition.Status != metav1.ConditionTrue {
+			SetCondition(conditions, v1.ConditionReady, metav1.ConditionFalse, condition.Reason, condition.Message, generation)
+			return
+		}
+	}
+	SetCondition(conditions, v1.ConditionReady, metav1.ConditionTrue, "Synced", "", generation)
+}
+
 // Utility stuff
 func findStringInSlice(slice []string, value string) int {
 	for idx, item := range slice {
@@ -34,3 +98,27 @@ func removeStringFromSlice(slice []string, value string) []string {
 	}
 	return slice
 }
+
+// resultForError decides how to retry after a pagerduty API error. Transient failures are returned,
+// so the request is retried with backoff. Retrying a permanent failure (a bad API key, an invalid request,
+// or an object another resource owns) won't help until something changes, so it's only retried after retryAfter, or on the next change if that's zero.
+func resultForError(err error, retryAfter time.Duration) (ctrl.Result, error) {
+	if err == nil {
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+	if _, notOwned := err.(*ownershipError); notOwned || pdhelpers.IsPermanent(err) {
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// errorReason is the condition reason for an error: its class when it's a pagerduty API error, or fallback
+func errorReason(err error, fallback string) string {
+	if _, notOwned := err.(*ownershipError); notOwned {
+		return "NotOwned"
+	}
+	if class := pdhelpers.ErrorClassOf(err); class != pdhelpers.ErrorUnknown {
+		return string(class)
+	}
+	return fallback
+}
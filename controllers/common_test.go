@@ -1,10 +1,14 @@
 package controllers
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "pagerduty-operator/api/v1"
 )
 
 // TestFinalizerLogic ensures that the add and remove finalizer
@@ -29,3 +33,59 @@ func TestFinalizerLogic(t *testing.T) {
 	EnsureFinalizerRemoved(&meta, "bar")
 	g.Expect(len(meta.Finalizers)).To(Equal(0))
 }
+
+// TestConditions checks how conditions are set and rolled up into Ready
+func TestConditions(t *testing.T) {
+	g := NewGomegaWithT(t)
+	conditions := []v1.Condition{}
+
+	SetCondition(&conditions, v1.ConditionServiceSynced, metav1.ConditionTrue, "Synced", "", 1)
+	g.Expect(conditions).To(HaveLen(1))
+	transition := conditions[0].LastTransitionTime
+	g.Expect(transition.IsZero()).To(BeFalse())
+
+	// Same status, so the transition time stays put
+	SetCondition(&conditions, v1.ConditionServiceSynced, metav1.ConditionTrue, "Synced", "", 2)
+	g.Expect(conditions).To(HaveLen(1))
+	g.Expect(conditions[0].LastTransitionTime).To(Equal(transition))
+	g.Expect(conditions[0].ObservedGeneration).To(Equal(int64(2)))
+
+	// Ready is unknown until everything it depends on has been checked
+	SetReadyCondition(&conditions, 2, v1.ConditionServiceSynced, v1.ConditionRuleSynced)
+	g.Expect(FindCondition(conditions, v1.ConditionReady).Status).To(Equal(metav1.ConditionUnknown))
+
+	SetConditionFromError(&conditions, v1.ConditionRuleSynced, fmt.Errorf("boom"), "SyncFailed", 2)
+	SetReadyCondition(&conditions, 2, v1.ConditionServiceSynced, v1.ConditionRuleSynced)
+	ready := FindCondition(conditions, v1.ConditionReady)
+	g.Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+	g.Expect(ready.Reason).To(Equal("SyncFailed"))
+	g.Expect(ready.Message).To(Equal("boom"))
+
+	SetConditionFromError(&conditions, v1.ConditionRuleSynced, nil, "SyncFailed", 2)
+	SetReadyCondition(&conditions, 2, v1.ConditionServiceSynced, v1.ConditionRuleSynced)
+	g.Expect(FindCondition(conditions, v1.ConditionReady).Status).To(Equal(metav1.ConditionTrue))
+	g.Expect(FindCondition(conditions, v1.ConditionDeleting)).To(BeNil())
+}
+
+// TestResultForError checks which errors are retried with backoff
+func TestResultForError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	result, err := resultForError(nil, time.Minute)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(time.Minute))
+
+	serverErr := fmt.Errorf("Failed call API endpoint. HTTP response code: 502. Error: bad gateway")
+	_, err = resultForError(serverErr, time.Minute)
+	g.Expect(err).To(Equal(serverErr))
+	g.Expect(errorReason(serverErr, "SyncFailed")).To(Equal("ServerError"))
+
+	// retrying an invalid request won't fix it
+	invalidErr := fmt.Errorf("Failed call API endpoint. HTTP response code: 400. Error: invalid input")
+	result, err = resultForError(invalidErr, time.Minute)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(time.Minute))
+	g.Expect(errorReason(invalidErr, "SyncFailed")).To(Equal("InvalidRequest"))
+
+	g.Expect(errorReason(fmt.Errorf("boom"), "SyncFailed")).To(Equal("SyncFailed"))
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	"github.com/dchest/uniuri"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
+)
+
+// marks the names of services disabled by the Disable deletion policy
+const archivedServiceSuffix = "archived"
+
+// how long to wait before checking again whether the open incidents blocking a deletion were resolved
+const openIncidentsRequeueDelay = time.Minute
+
+// openIncidentsError keeps a service from being deleted while it has open incidents
+type openIncidentsError struct {
+	serviceID string
+	incidents []pagerduty.Incident
+}
+
+func (e *openIncidentsError) Error() string {
+	numbers := make([]string, 0, len(e.incidents))
+	for _, incident := range e.incidents {
+		numbers = append(numbers, fmt.Sprintf("#%d", incident.IncidentNumber))
+	}
+	return fmt.Sprintf("Service %s has %d open incidents (%s). Resolve them, or set the %s annotation to delete it anyway",
+		e.serviceID, len(e.incidents), strings.Join(numbers, ", "), v1.IgnoreOpenIncidentsAnnotation)
+}
+
+// IsKnownDeletionPolicy reports whether the operator knows how to apply the deletion policy
+func IsKnownDeletionPolicy(policy v1.DeletionPolicy) bool {
+	switch policy {
+	case v1.DeletionPolicyDelete, v1.DeletionPolicyRetain, v1.DeletionPolicyDisable:
+		return true
+	}
+	return false
+}
+
+// IsKnownOpenIncidentsPolicy reports whether the operator knows how to apply the open incidents policy
+func IsKnownOpenIncidentsPolicy(policy v1.OpenIncidentsPolicy) bool {
+	switch policy {
+	case v1.OpenIncidentsBlock, v1.OpenIncidentsResolve, v1.OpenIncidentsIgnore:
+		return true
+	}
+	return false
+}
+
+// deletionPolicy works out what to do with the service when the resource is deleted.
+// Adopted services weren't ours to begin with, so they're retained unless the spec says otherwise.
+func (r *PagerdutyServiceReconciler) deletionPolicy(kubeService *v1.PagerdutyService) v1.DeletionPolicy {
+	switch {
+	case kubeService.Spec.DeletionPolicy != "":
+		return kubeService.Spec.DeletionPolicy
+	case kubeService.Status.Adopted:
+		return v1.DeletionPolicyRetain
+	case r.DefaultDeletionPolicy != "":
+		return r.DefaultDeletionPolicy
+	}
+	return v1.DeletionPolicyDelete
+}
+
+// disablePdService disables the service and marks its name as archived, keeping its incident history
+func (r *PagerdutyServiceReconciler) disablePdService(kubeService *v1.PagerdutyService) error {
+	pdService, err := r.PdClient.GetService(kubeService.Status.ServiceID, &pagerduty.GetServiceOptions{})
+	if err != nil {
+		return err
+	}
+	if pdService.Status == "disabled" {
+		// by an earlier attempt
+		return nil
+	}
+	pdService.Status = "disabled"
+	// no longer ours, so the garbage collector leaves it alone
+	pdService.Description = withoutOwnerMarker(pdService.Description)
+	pdService.Name = withNameSuffix(pdService.Name, archivedServiceSuffix)
+	_, err = r.PdClient.UpdateService(*pdService)
+	if pdhelpers.IsNameTaken(err) {
+		// an earlier service with the same name was archived already
+		pdService.Name = withNameSuffix(pdService.Name, uniuri.NewLen(serviceNameSuffixLen))
+		_, err = r.PdClient.UpdateService(*pdService)
+	}
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Disabled service %s and renamed it to %s", pdService.ID, pdService.Name)
+	logger.Info(msg)
+	r.EventRecorder.Event(kubeService, "Normal", "Disabled", msg)
+	return nil
+}
+
+// openIncidentsPolicy works out what to do about open incidents when the service is deleted
+func (r *PagerdutyServiceReconciler) openIncidentsPolicy(kubeService *v1.PagerdutyService) v1.OpenIncidentsPolicy {
+	switch {
+	case kubeService.Annotations[v1.IgnoreOpenIncidentsAnnotation] == "true":
+		return v1.OpenIncidentsIgnore
+	case kubeService.Spec.OpenIncidentsPolicy != "":
+		return kubeService.Spec.OpenIncidentsPolicy
+	case r.DefaultOpenIncidentsPolicy != "":
+		return r.DefaultOpenIncidentsPolicy
+	}
+	return v1.OpenIncidentsBlock
+}
+
+// checkOpenIncidentsPolicy fails when the service's open incidents couldn't be dealt with once the
+// resource is deleted, so the mistake shows up while it can still be fixed
+func (r *PagerdutyServiceReconciler) checkOpenIncidentsPolicy(kubeService *v1.PagerdutyService) error {
+	if r.deletionPolicy(kubeService) != v1.DeletionPolicyDelete || r.openIncidentsPolicy(kubeService) != v1.OpenIncidentsResolve {
+		return nil
+	}
+	if r.FromEmail == "" {
+		return fmt.Errorf("Unable to resolve open incidents without the operator's -from-email")
+	}
+	return nil
+}
+
+// handleOpenIncidents deals with the open incidents of a service that's about to be deleted,
+// returning an openIncidentsError if they block the deletion
+func (r *PagerdutyServiceReconciler) handleOpenIncidents(kubeService *v1.PagerdutyService) error {
+	policy := r.openIncidentsPolicy(kubeService)
+	if policy == v1.OpenIncidentsIgnore {
+		return nil
+	}
+	serviceID := kubeService.Status.ServiceID
+	helper := pdhelpers.IncidentHelper{IncidentClient: r.PdClient}
+	incidents, err := helper.ListOpenIncidents(serviceID)
+	if err != nil || len(incidents) == 0 {
+		return err
+	}
+	if policy != v1.OpenIncidentsResolve {
+		return &openIncidentsError{serviceID: serviceID, incidents: incidents}
+	}
+
+	if r.FromEmail == "" {
+		return fmt.Errorf("Unable to resolve the open incidents of service %s without the operator's -from-email", serviceID)
+	}
+	note := fmt.Sprintf("Resolved by pagerduty-operator, because PagerdutyService %s/%s was deleted", kubeService.Namespace, kubeService.Name)
+	if err = helper.ResolveIncidents(r.FromEmail, incidents, note); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("Resolved %d open incidents of service %s", len(incidents), serviceID)
+	logger.Info(msg)
+	r.EventRecorder.Event(kubeService, "Normal", "ResolvedIncidents", msg)
+	return nil
+}
+
+// abandonCleanup removes the finalizer after a failed cleanup when forced to, by the annotation or
+// the cleanup timeout, recording the pagerduty objects left behind. Otherwise it returns cleanupErr.
+func (r *PagerdutyServiceReconciler) abandonCleanup(ctx context.Context, kubeService *v1.PagerdutyService, cleanupErr error) error {
+	timeout := r.CleanupTimeout
+	if _, blocked := cleanupErr.(*openIncidentsError); blocked {
+		// open incidents don't go away by waiting
+		timeout = 0
+	}
+	reason := forceRemovalReason(&kubeService.ObjectMeta, timeout, time.Now())
+	if reason == "" {
+		return cleanupErr
+	}
+
+	orphans := OrphanRecord{
+		Kind:       "PagerdutyService",
+		Namespace:  kubeService.Namespace,
+		Name:       kubeService.Name,
+		ServiceID:  kubeService.Status.ServiceID,
+		RulesetID:  r.managedRulesetID(&kubeService.Status),
+		RuleIDs:    managedRuleIDs(&kubeService.Status),
+		Reason:     fmt.Sprintf("%s: %v", reason, cleanupErr),
+		OrphanedAt: metav1.Now(),
+		// the garbage collector leaves retained and disabled services alone, marker or not
+		DeletionPolicy: r.deletionPolicy(kubeService),
+	}
+	if orphans.DeletionPolicy == v1.DeletionPolicyRetain && orphans.ServiceID != "" {
+		// not needed while the record is in the ledger, but tidy when pagerduty can be reached
+		if err := r.releasePdService(orphans.ServiceID, r.ownerOf(kubeService)); err != nil && !pdhelpers.IsNotFound(err) {
+			logger.Error(err, "Unable to remove the ownership marker of retained service", "serviceID", orphans.ServiceID)
+		}
+	}
+	if len(orphans.RuleIDs) == 0 {
+		orphans.RulesetID = ""
+	}
+	return recordOrphans(ctx, r.Orphans, r.EventRecorder, kubeService, kubeService.UID, orphans)
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+func TestDeletionPolicy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	r := &PagerdutyServiceReconciler{}
+
+	kubeService := &v1.PagerdutyService{}
+	g.Expect(r.deletionPolicy(kubeService)).To(Equal(v1.DeletionPolicyDelete))
+
+	r.DefaultDeletionPolicy = v1.DeletionPolicyDisable
+	g.Expect(r.deletionPolicy(kubeService)).To(Equal(v1.DeletionPolicyDisable))
+
+	// adopted services aren't ours to delete, unless the spec says so
+	kubeService.Status.Adopted = true
+	g.Expect(r.deletionPolicy(kubeService)).To(Equal(v1.DeletionPolicyRetain))
+	kubeService.Spec.DeletionPolicy = v1.DeletionPolicyDelete
+	g.Expect(r.deletionPolicy(kubeService)).To(Equal(v1.DeletionPolicyDelete))
+
+	g.Expect(IsKnownDeletionPolicy("Orphan")).To(BeFalse())
+}
+
+// TestDestroyWithDeletionPolicy checks what's left of the service after the resource is deleted
+func TestDestroyWithDeletionPolicy(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	newService := func(policy v1.DeletionPolicy) (*v1.PagerdutyService, *PagerdutyClientMock) {
+		kubeService := &v1.PagerdutyService{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+			Spec:       v1.PagerdutyServiceSpec{DeletionPolicy: policy},
+			Status:     v1.PagerdutyServiceStatus{ServiceID: "SVC", RuleIDs: []string{"RULE"}, RulesetID: "RS1"},
+		}
+		pdClient := &PagerdutyClientMock{
+			service:     &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "SVC"}, Name: "api", Status: "active"},
+			rulesetRule: &pagerduty.RulesetRule{ID: "RULE"},
+		}
+		return kubeService, pdClient
+	}
+	newReconciler := func(pdClient *PagerdutyClientMock) *PagerdutyServiceReconciler {
+		r := newTestReconciler()
+		r.PdClient = pdClient
+		return r
+	}
+
+	kubeService, pdClient := newService(v1.DeletionPolicyDelete)
+	g.Expect(newReconciler(pdClient).destroyPagerdutyResources(kubeService)).To(Succeed())
+	g.Expect(pdClient.service).To(BeNil())
+	g.Expect(pdClient.rulesetRule).To(BeNil())
+
+	kubeService, pdClient = newService(v1.DeletionPolicyRetain)
+	g.Expect(newReconciler(pdClient).destroyPagerdutyResources(kubeService)).To(Succeed())
+	g.Expect(pdClient.service.Name).To(Equal("api"))
+	g.Expect(pdClient.service.Status).To(Equal("active"))
+	g.Expect(pdClient.rulesetRule).To(BeNil())
+
+	kubeService, pdClient = newService(v1.DeletionPolicyDisable)
+	r := newReconciler(pdClient)
+	g.Expect(r.destroyPagerdutyResources(kubeService)).To(Succeed())
+	g.Expect(pdClient.service.Name).To(Equal("api-archived"))
+	g.Expect(pdClient.service.Status).To(Equal("disabled"))
+	g.Expect(pdClient.rulesetRule).To(BeNil())
+
+	// retrying doesn't archive it twice
+	g.Expect(r.destroyPagerdutyResources(kubeService)).To(Succeed())
+	g.Expect(pdClient.service.Name).To(Equal("api-archived"))
+
+	// an older "api" was archived already
+	kubeService, pdClient = newService(v1.DeletionPolicyDisable)
+	pdClient.takenNames = map[string]bool{"api-archived": true}
+	g.Expect(newReconciler(pdClient).destroyPagerdutyResources(kubeService)).To(Succeed())
+	g.Expect(strings.HasPrefix(pdClient.service.Name, "api-archived-")).To(BeTrue())
+}
+
+// TestOpenIncidentsBlockDeletion ensures services aren't deleted out from under their open incidents
+func TestOpenIncidentsBlockDeletion(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := metav1.Now()
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", DeletionTimestamp: &now, Finalizers: []string{finalizerKey}},
+		Status:     v1.PagerdutyServiceStatus{ServiceID: "SVC", RuleIDs: []string{"RULE"}, RulesetID: "RS1"},
+	}
+	pdClient := &PagerdutyClientMock{
+		service:       &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "SVC"}, Name: "api"},
+		rulesetRule:   &pagerduty.RulesetRule{ID: "RULE"},
+		openIncidents: []pagerduty.Incident{{APIObject: pagerduty.APIObject{ID: "INC"}, IncidentNumber: 42}},
+	}
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(openIncidentsRequeueDelay))
+	g.Expect(<-recorder.Events).To(ContainSubstring("1 open incidents (#42)"))
+
+	// nothing was deleted, and the resource says why
+	g.Expect(pdClient.service).ToNot(BeNil())
+	g.Expect(pdClient.rulesetRule).ToNot(BeNil())
+	var blocked v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &blocked)).To(Succeed())
+	g.Expect(blocked.Finalizers).To(ContainElement(finalizerKey))
+	g.Expect(FindCondition(blocked.Status.Conditions, v1.ConditionDeleting).Reason).To(Equal("OpenIncidents"))
+
+	// resolving them needs someone to resolve them as
+	kubeService.Spec.OpenIncidentsPolicy = v1.OpenIncidentsResolve
+	g.Expect(r.destroyPagerdutyResources(kubeService)).ToNot(Succeed())
+	g.Expect(pdClient.service).ToNot(BeNil())
+
+	r.FromEmail = "operator@example.com"
+	g.Expect(r.destroyPagerdutyResources(kubeService)).To(Succeed())
+	g.Expect(pdClient.openIncidents).To(BeEmpty())
+	g.Expect(pdClient.incidentNotes["INC"]).To(HaveLen(1))
+	g.Expect(pdClient.incidentNotes["INC"][0].Content).To(ContainSubstring("PagerdutyService default/api was deleted"))
+	g.Expect(pdClient.service).To(BeNil())
+}
+
+func TestIgnoreOpenIncidents(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "api",
+			Namespace:   "default",
+			Annotations: map[string]string{v1.IgnoreOpenIncidentsAnnotation: "true"},
+		},
+		Spec:   v1.PagerdutyServiceSpec{OpenIncidentsPolicy: v1.OpenIncidentsBlock},
+		Status: v1.PagerdutyServiceStatus{ServiceID: "SVC"},
+	}
+	pdClient := &PagerdutyClientMock{
+		service:       &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "SVC"}},
+		openIncidents: []pagerduty.Incident{{APIObject: pagerduty.APIObject{ID: "INC"}}},
+	}
+	r := newTestReconciler()
+	r.PdClient = pdClient
+	g.Expect(r.destroyPagerdutyResources(kubeService)).To(Succeed())
+	g.Expect(pdClient.service).To(BeNil())
+	g.Expect(pdClient.openIncidents).To(HaveLen(1))
+
+	// retained services keep their incidents, so they aren't checked
+	kubeService.Annotations = nil
+	kubeService.Spec.DeletionPolicy = v1.DeletionPolicyRetain
+	g.Expect(r.destroyPagerdutyResources(kubeService)).To(Succeed())
+
+	g.Expect(IsKnownOpenIncidentsPolicy("Wait")).To(BeFalse())
+}
+
+// TestResolveWithoutFromEmail ensures resources that couldn't resolve their incidents are rejected up front
+func TestResolveWithoutFromEmail(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Spec: v1.PagerdutyServiceSpec{
+			EscalationPolicy:    "EP1",
+			OpenIncidentsPolicy: v1.OpenIncidentsResolve,
+			SelectorSpec:        v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+		},
+	}
+	pdClient := &PagerdutyClientMock{}
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	var rejected v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &rejected)).To(Succeed())
+	g.Expect(FindCondition(rejected.Status.Conditions, v1.ConditionServiceSynced).Reason).To(Equal("InvalidOpenIncidentsPolicy"))
+	g.Expect(isConditionTrue(rejected.Status.Conditions, v1.ConditionReady)).To(BeFalse())
+	g.Expect(pdClient.service).To(BeNil())
+
+	// retained services don't resolve anything
+	g.Expect(r.checkOpenIncidentsPolicy(&v1.PagerdutyService{Spec: v1.PagerdutyServiceSpec{
+		OpenIncidentsPolicy: v1.OpenIncidentsResolve,
+		DeletionPolicy:      v1.DeletionPolicyRetain,
+	}})).To(Succeed())
+
+	r.FromEmail = "operator@example.com"
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pdClient.service).ToNot(BeNil())
+}
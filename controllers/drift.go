@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+)
+
+// serviceDrift lists the fields of a live pagerduty service that differ from the desired ones.
+// An empty name isn't compared, and neither is the description's ownership marker.
+func serviceDrift(live *pagerduty.Service, name string, description string, escalationPolicyID string) []string {
+	drift := make([]string, 0)
+	if name != "" && live.Name != name {
+		drift = append(drift, "name")
+	}
+	if withoutOwnerMarker(live.Description) != description {
+		drift = append(drift, "description")
+	}
+	if live.EscalationPolicy.ID != escalationPolicyID {
+		drift = append(drift, "escalationPolicy")
+	}
+	return drift
+}
+
+// ruleDrift lists the parts of a live ruleset rule that differ from the desired rule.
+// Position is left out, since rule order is managed separately, and so is the ownership marker.
+func ruleDrift(live *pagerduty.RulesetRule, desired *pagerduty.RulesetRule) []string {
+	drift := make([]string, 0)
+	if !equivalentJSON(withoutRuleOwner(live.Conditions), withoutRuleOwner(desired.Conditions)) {
+		drift = append(drift, "conditions")
+	}
+	if !equivalentJSON(live.Actions, desired.Actions) {
+		drift = append(drift, "actions")
+	}
+	if !equivalentJSON(live.TimeFrame, desired.TimeFrame) {
+		drift = append(drift, "timeFrame")
+	}
+	return drift
+}
+
+// equivalentJSON compares two values by their JSON representation. Pagerduty fills in
+// empty objects for actions that aren't set (e.g. "suppress": {"value": false}),
+// so empty values are pruned before comparing.
+func equivalentJSON(a interface{}, b interface{}) bool {
+	return reflect.DeepEqual(prunedJSON(a), prunedJSON(b))
+}
+
+func prunedJSON(value interface{}) interface{} {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err = json.Unmarshal(raw, &decoded); err != nil {
+		return nil
+	}
+	return prune(decoded)
+}
+
+// prune drops false, zero, empty and null values, returning nil when nothing is left
+func prune(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		pruned := make(map[string]interface{})
+		for key, item := range v {
+			if item = prune(item); item != nil {
+				pruned[key] = item
+			}
+		}
+		if len(pruned) == 0 {
+			return nil
+		}
+		return pruned
+	case []interface{}:
+		pruned := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			pruned = append(pruned, prune(item))
+		}
+		if len(pruned) == 0 {
+			return nil
+		}
+		return pruned
+	case string:
+		if v == "" {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	}
+	return value
+}
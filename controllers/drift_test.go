@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"testing"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+)
+
+func TestServiceDrift(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	live := &pagerduty.Service{
+		Name:             "svc",
+		Description:      "edited in the UI",
+		EscalationPolicy: pagerduty.EscalationPolicy{APIObject: pagerduty.APIObject{ID: "EP1"}},
+	}
+	g.Expect(serviceDrift(live, "svc", "edited in the UI", "EP1")).To(BeEmpty())
+	g.Expect(serviceDrift(live, "", "from the spec", "EP1")).To(Equal([]string{"description"}))
+	g.Expect(serviceDrift(live, "svc", "from the spec", "EP2")).To(Equal([]string{"description", "escalationPolicy"}))
+	g.Expect(serviceDrift(live, "renamed", "edited in the UI", "EP1")).To(Equal([]string{"name"}))
+}
+
+// TestRuleDrift ensures rules are only considered changed when their content differs
+func TestRuleDrift(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	desired := &pagerduty.RulesetRule{
+		Conditions: &pagerduty.RuleConditions{
+			Operator: "and",
+			RuleSubconditions: []*pagerduty.RuleSubcondition{
+				{Operator: pdOpContains, Parameters: &pagerduty.ConditionParameter{Path: firingPath, Value: "app = foo"}},
+			},
+		},
+		Actions: &pagerduty.RuleActions{Route: &pagerduty.RuleActionParameter{Value: "SVC"}},
+	}
+
+	// pagerduty fills in the actions that aren't set, and the rule's position and ID
+	position := 3
+	live := &pagerduty.RulesetRule{
+		ID:         "RULE",
+		Position:   &position,
+		Conditions: desired.Conditions,
+		Actions: &pagerduty.RuleActions{
+			Route:       &pagerduty.RuleActionParameter{Value: "SVC"},
+			Suppress:    &pagerduty.RuleActionSuppress{Value: false},
+			Annotate:    &pagerduty.RuleActionParameter{},
+			Extractions: []*pagerduty.RuleActionExtraction{},
+		},
+		TimeFrame: &pagerduty.RuleTimeFrame{},
+	}
+	g.Expect(ruleDrift(live, desired)).To(BeEmpty())
+
+	live.Conditions = &pagerduty.RuleConditions{
+		Operator: "and",
+		RuleSubconditions: []*pagerduty.RuleSubcondition{
+			{Operator: pdOpContains, Parameters: &pagerduty.ConditionParameter{Path: firingPath, Value: "app = bar"}},
+		},
+	}
+	live.Actions.Severity = &pagerduty.RuleActionParameter{Value: "critical"}
+	g.Expect(ruleDrift(live, desired)).To(Equal([]string{"conditions", "actions"}))
+
+	live = &pagerduty.RulesetRule{
+		Conditions: desired.Conditions,
+		Actions:    desired.Actions,
+		TimeFrame:  &pagerduty.RuleTimeFrame{ActiveBetween: &pagerduty.ActiveBetween{StartTime: 1, EndTime: 2}},
+	}
+	g.Expect(ruleDrift(live, desired)).To(Equal([]string{"timeFrame"}))
+}
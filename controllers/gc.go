@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
+)
+
+const (
+	garbageService = "service"
+	garbageRule    = "rule"
+)
+
+var (
+	gcOrphans = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pagerduty_gc_orphans",
+		Help: "Orphaned pagerduty objects found by the last garbage collection, by kind",
+	}, []string{"kind"})
+	gcCollected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pagerduty_gc_collected_total",
+		Help: "Orphaned pagerduty objects deleted by the garbage collector, by kind",
+	}, []string{"kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(gcOrphans, gcCollected)
+}
+
+var gcLogger = ctrl.Log.WithName("garbageCollector")
+
+// GarbageCollectorPagerdutyInterface is what the garbage collector needs of the pagerduty client
+type GarbageCollectorPagerdutyInterface interface {
+	pdhelpers.ServiceClient
+	pdhelpers.RulesetRuleClient
+}
+
+// Garbage is a pagerduty object the operator created, which no resource uses anymore
+type Garbage struct {
+	// Kind is "service" or "rule"
+	Kind string
+	ID   string
+	// RulesetID is the ruleset a rule is in
+	RulesetID string
+	// ServiceID is the service a rule routes to
+	ServiceID string
+	Reason    string
+}
+
+func (g Garbage) key() string {
+	return fmt.Sprintf("%s/%s/%s", g.Kind, g.RulesetID, g.ID)
+}
+
+// GarbageCollector deletes the services and rules this cluster's operator created that no resource
+// uses anymore, like the ones left behind by a crash before their ID was saved, or by a finalizer
+// that was removed by force. Unmarked rules routing to those services are deleted along with them.
+//
+// Only objects with this cluster's ownership marker are considered, and only the ones found
+// orphaned by two passes in a row are deleted, so objects being created aren't mistaken for orphans.
+type GarbageCollector struct {
+	Client   client.Client
+	PdClient GarbageCollectorPagerdutyInterface
+
+	// ClusterID is the owner cluster of the objects that may be collected
+	ClusterID string
+	// RulesetID is the operator's default ruleset
+	RulesetID string
+	// Interval is the time between passes
+	Interval time.Duration
+	// DryRun only reports the orphans
+	DryRun bool
+	// Orphans lists the services whose finalizer was removed by force. The ones their resource
+	// meant to retain or disable are never collected, since their marker may still be on them.
+	Orphans *OrphanLedger
+
+	// suspects were orphaned in the previous pass
+	suspects map[string]bool
+}
+
+// Start collects garbage every Interval until stop is closed
+func (gc *GarbageCollector) Start(stop <-chan struct{}) error {
+	gcLogger.Info("Starting garbage collection", "interval", gc.Interval, "dryRun", gc.DryRun)
+	ticker := time.NewTicker(gc.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if _, err := gc.Collect(context.Background()); err != nil {
+				gcLogger.Error(err, "Garbage collection failed")
+			}
+		}
+	}
+}
+
+// Collect finds the orphaned objects, and deletes the ones that were orphaned in the previous pass too.
+// It returns all the orphans it found.
+func (gc *GarbageCollector) Collect(ctx context.Context) ([]Garbage, error) {
+	orphans, err := gc.findOrphans(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{garbageService: 0, garbageRule: 0}
+	suspects := make(map[string]bool)
+	// services whose rules couldn't be deleted are kept, or their rules could never be found again
+	keep := make(map[string]bool)
+	var firstErr error
+	for _, orphan := range orphans {
+		counts[orphan.Kind]++
+		suspects[orphan.key()] = true
+		log := gcLogger.WithValues("kind", orphan.Kind, "id", orphan.ID, "reason", orphan.Reason)
+		if gc.DryRun {
+			log.Info("Found orphan (dry run)")
+			continue
+		}
+		if !gc.suspects[orphan.key()] {
+			log.Info("Found orphan, deleting it next pass if it's still orphaned")
+			continue
+		}
+		if orphan.Kind == garbageService && keep[orphan.ID] {
+			log.Info("Keeping orphan until its rules are deleted")
+			continue
+		}
+
+		err = gc.delete(orphan)
+		if err != nil && !pdhelpers.IsNotFound(err) {
+			log.Error(err, "Failed to delete orphan")
+			if firstErr == nil {
+				firstErr = err
+			}
+			if orphan.Kind == garbageRule {
+				keep[orphan.ServiceID] = true
+			}
+			continue
+		}
+		log.Info("Deleted orphan")
+		gcCollected.WithLabelValues(orphan.Kind).Inc()
+	}
+	gc.suspects = suspects
+
+	for kind, count := range counts {
+		gcOrphans.WithLabelValues(kind).Set(float64(count))
+	}
+	return orphans, firstErr
+}
+
+func (gc *GarbageCollector) delete(orphan Garbage) error {
+	if orphan.Kind == garbageRule {
+		return gc.PdClient.DeleteRulesetRule(orphan.RulesetID, orphan.ID)
+	}
+	return gc.PdClient.DeleteService(orphan.ID)
+}
+
+// findOrphans lists the orphaned rules, followed by the orphaned services they route to
+func (gc *GarbageCollector) findOrphans(ctx context.Context) ([]Garbage, error) {
+	var kubeServices v1.PagerdutyServiceList
+	if err := gc.Client.List(ctx, &kubeServices); err != nil {
+		return nil, err
+	}
+	var kubeRulesets v1.PagerdutyRulesetList
+	if err := gc.Client.List(ctx, &kubeRulesets); err != nil {
+		return nil, err
+	}
+
+	owners := make(map[types.NamespacedName]bool)
+	usedServices := make(map[string]bool)
+	usedRules := make(map[string]bool)
+	rulesets := make(map[string]bool)
+	if gc.RulesetID != "" {
+		rulesets[gc.RulesetID] = true
+	}
+	for _, kubeService := range kubeServices.Items {
+		owners[types.NamespacedName{Namespace: kubeService.Namespace, Name: kubeService.Name}] = true
+		usedServices[kubeService.Status.ServiceID] = true
+		for _, ruleID := range managedRuleIDs(&kubeService.Status) {
+			usedRules[ruleID] = true
+		}
+		if kubeService.Status.RulesetID != "" {
+			rulesets[kubeService.Status.RulesetID] = true
+		}
+	}
+	for _, kubeRuleset := range kubeRulesets.Items {
+		usedServices[kubeRuleset.Status.CatchallServiceID] = true
+		usedRules[kubeRuleset.Status.CatchallRuleID] = true
+		if kubeRuleset.Status.RulesetID != "" {
+			rulesets[kubeRuleset.Status.RulesetID] = true
+		}
+	}
+
+	keptServices := make(map[string]bool)
+	if gc.Orphans != nil {
+		records, err := gc.Orphans.Records(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if record.keepsService() {
+				keptServices[record.ServiceID] = true
+			}
+		}
+	}
+
+	helper := pdhelpers.ServiceHelper{ServiceClient: gc.PdClient}
+	pdServices, err := helper.ListAllServices()
+	if err != nil {
+		return nil, err
+	}
+	services := make([]Garbage, 0)
+	orphanedServices := make(map[string]bool)
+	for _, pdService := range pdServices {
+		owner, owned := parseOwner(pdService.Description)
+		if !owned || owner.Cluster != gc.ClusterID || usedServices[pdService.ID] || keptServices[pdService.ID] {
+			continue
+		}
+		services = append(services, Garbage{Kind: garbageService, ID: pdService.ID, Reason: orphanReason(owner, owners)})
+		orphanedServices[pdService.ID] = true
+	}
+
+	rulesetIDs := make([]string, 0, len(rulesets))
+	for rulesetID := range rulesets {
+		rulesetIDs = append(rulesetIDs, rulesetID)
+	}
+	sort.Strings(rulesetIDs)
+
+	orphans := make([]Garbage, 0, len(services))
+	for _, rulesetID := range rulesetIDs {
+		rules, err := gc.PdClient.ListRulesetRules(rulesetID)
+		if pdhelpers.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules.Rules {
+			if rule.CatchAll || usedRules[rule.ID] || rule.Actions == nil || rule.Actions.Route == nil {
+				continue
+			}
+			orphan := Garbage{Kind: garbageRule, ID: rule.ID, RulesetID: rulesetID, ServiceID: rule.Actions.Route.Value}
+			if owner, owned := parseOwner(ruleOwnerMarker(rule)); owned {
+				if owner.Cluster != gc.ClusterID {
+					continue
+				}
+				orphan.Reason = orphanReason(owner, owners)
+			} else if orphanedServices[orphan.ServiceID] {
+				// created before rules had ownership markers, or its marker was edited away
+				orphan.Reason = fmt.Sprintf("routes to orphaned service %s", orphan.ServiceID)
+			} else {
+				continue
+			}
+			orphans = append(orphans, orphan)
+		}
+	}
+	return append(orphans, services...), nil
+}
+
+// orphanReason explains why nothing uses an object the owner created
+func orphanReason(owner Owner, owners map[types.NamespacedName]bool) string {
+	if !owners[types.NamespacedName{Namespace: owner.Namespace, Name: owner.Name}] {
+		return fmt.Sprintf("PagerdutyService %s/%s no longer exists", owner.Namespace, owner.Name)
+	}
+	return fmt.Sprintf("PagerdutyService %s/%s doesn't use it", owner.Namespace, owner.Name)
+}
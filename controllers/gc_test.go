@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
+)
+
+type fakeGarbageClient struct {
+	pdhelpers.FakeServiceClient
+	pdhelpers.FakeRulesetClient
+}
+
+func newGarbageTestClient() fakeGarbageClient {
+	pdClient := fakeGarbageClient{pdhelpers.NewFakeServiceClient(), pdhelpers.NewFakeRulesetClient()}
+	ownedBy := func(cluster string, name string) string {
+		return withOwnerMarker("", Owner{Cluster: cluster, Namespace: "default", Name: name})
+	}
+	for _, service := range []pagerduty.Service{
+		{APIObject: pagerduty.APIObject{ID: "USED"}, Description: ownedBy("east", "api")},
+		{APIObject: pagerduty.APIObject{ID: "GONE"}, Description: ownedBy("east", "deleted")},
+		{APIObject: pagerduty.APIObject{ID: "DUPLICATE"}, Description: ownedBy("east", "api")},
+		{APIObject: pagerduty.APIObject{ID: "WEST"}, Description: ownedBy("west", "deleted")},
+		{APIObject: pagerduty.APIObject{ID: "HANDMADE"}, Description: "made by hand"},
+	} {
+		_, _ = pdClient.CreateService(service)
+	}
+
+	routeTo := func(id string, serviceID string) *pagerduty.RulesetRule {
+		return &pagerduty.RulesetRule{ID: id, Actions: &pagerduty.RuleActions{Route: &pagerduty.RuleActionParameter{Value: serviceID}}}
+	}
+	markedBy := func(id string, serviceID string, cluster string) *pagerduty.RulesetRule {
+		rule := routeTo(id, serviceID)
+		rule.Conditions = withRuleOwner(nil, Owner{Cluster: cluster, Namespace: "default", Name: "api"})
+		return rule
+	}
+	catchall := routeTo("CATCHALL", "GONE")
+	catchall.CatchAll = true
+	pdClient.Rules["RS"] = map[string]*pagerduty.RulesetRule{
+		"USED": routeTo("USED", "USED"),
+		"GONE": routeTo("GONE", "GONE"),
+		// no resource lists it, but it may have been added by hand to a service that's in use
+		"EXTRA": routeTo("EXTRA", "USED"),
+		// created for the resource, but it lost track of it
+		"LOST": markedBy("LOST", "USED", "east"),
+		// west's rules are west's to collect, whatever they route to
+		"WEST":     markedBy("WEST", "GONE", "west"),
+		"HANDMADE": routeTo("HANDMADE", "HANDMADE"),
+		"CATCHALL": catchall,
+	}
+	return pdClient
+}
+
+// TestGarbageCollector ensures only this cluster's services and rules that nothing uses are collected,
+// along with the unmarked rules routing to those services
+func TestGarbageCollector(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Status:     v1.PagerdutyServiceStatus{ServiceID: "USED", RulesetID: "RS", RuleIDs: []string{"USED"}},
+	}
+	kubeClient := newTestReconciler(kubeService).Client
+
+	// a dry run only reports the orphans
+	pdClient := newGarbageTestClient()
+	gc := &GarbageCollector{Client: kubeClient, PdClient: pdClient, ClusterID: "east", RulesetID: "RS", DryRun: true}
+	for i := 0; i < 2; i++ {
+		orphans, err := gc.Collect(ctx)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(orphans).To(ConsistOf(
+			Garbage{Kind: garbageRule, ID: "GONE", RulesetID: "RS", ServiceID: "GONE", Reason: "routes to orphaned service GONE"},
+			Garbage{Kind: garbageRule, ID: "LOST", RulesetID: "RS", ServiceID: "USED", Reason: "PagerdutyService default/api doesn't use it"},
+			Garbage{Kind: garbageService, ID: "GONE", Reason: "PagerdutyService default/deleted no longer exists"},
+			Garbage{Kind: garbageService, ID: "DUPLICATE", Reason: "PagerdutyService default/api doesn't use it"},
+		))
+	}
+	g.Expect(pdClient.ServicesByID).To(HaveLen(5))
+	g.Expect(pdClient.Rules["RS"]).To(HaveLen(7))
+
+	// orphans are deleted when the next pass finds them again
+	gc = &GarbageCollector{Client: kubeClient, PdClient: pdClient, ClusterID: "east", RulesetID: "RS"}
+	_, err := gc.Collect(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pdClient.ServicesByID).To(HaveLen(5))
+
+	_, err = gc.Collect(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pdClient.ServicesByID).To(HaveLen(3))
+	g.Expect(pdClient.ServicesByID).To(HaveKey("USED"))
+	g.Expect(pdClient.ServicesByID).To(HaveKey("WEST"))
+	g.Expect(pdClient.ServicesByID).To(HaveKey("HANDMADE"))
+	g.Expect(pdClient.Rules["RS"]).To(HaveLen(5))
+	g.Expect(pdClient.Rules["RS"]).ToNot(HaveKey("GONE"))
+	g.Expect(pdClient.Rules["RS"]).ToNot(HaveKey("LOST"))
+	g.Expect(pdClient.Rules["RS"]).To(HaveKey("EXTRA"))
+	g.Expect(pdClient.Rules["RS"]).To(HaveKey("WEST"))
+
+	orphans, err := gc.Collect(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(orphans).To(BeEmpty())
+}
+
+// TestGarbageCollectorSuspects ensures services are only collected when two passes in a row find them orphaned
+func TestGarbageCollectorSuspects(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Status:     v1.PagerdutyServiceStatus{ServiceID: "USED"},
+	}
+	kubeClient := newTestReconciler(kubeService).Client
+	pdClient := newGarbageTestClient()
+	gc := &GarbageCollector{Client: kubeClient, PdClient: pdClient, ClusterID: "east"}
+
+	orphans, err := gc.Collect(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(orphans).To(HaveLen(2))
+
+	// the resource picked up the duplicate in between
+	kubeService.Status.ServiceID = "DUPLICATE"
+	g.Expect(kubeClient.Status().Update(ctx, kubeService)).To(Succeed())
+	_, err = gc.Collect(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pdClient.ServicesByID).To(HaveKey("DUPLICATE"))
+	// and the service it no longer uses gets another pass before it's collected
+	g.Expect(pdClient.ServicesByID).To(HaveKey("USED"))
+	g.Expect(pdClient.ServicesByID).ToNot(HaveKey("GONE"))
+}
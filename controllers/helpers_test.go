@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+// newTestReconciler returns a reconciler backed by a fake client holding the objects,
+// an empty pagerduty mock, the default ruleset RS1 and a recorder with room for 10 events.
+// Tests replace whatever else they need.
+func newTestReconciler(objects ...runtime.Object) *PagerdutyServiceReconciler {
+	testScheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(testScheme); err != nil {
+		panic(err)
+	}
+	if err := v1.AddToScheme(testScheme); err != nil {
+		panic(err)
+	}
+	return &PagerdutyServiceReconciler{
+		Client:        fake.NewFakeClientWithScheme(testScheme, objects...),
+		Log:           ctrl.Log.WithName("test"),
+		PdClient:      &PagerdutyClientMock{},
+		RulesetID:     "RS1",
+		EventRecorder: record.NewFakeRecorder(10),
+	}
+}
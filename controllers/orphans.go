@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+// OrphanRecord lists the pagerduty objects a deleted resource left behind
+type OrphanRecord struct {
+	Kind       string      `json:"kind"`
+	Namespace  string      `json:"namespace"`
+	Name       string      `json:"name"`
+	ServiceID  string      `json:"serviceID,omitempty"`
+	RulesetID  string      `json:"rulesetID,omitempty"`
+	RuleIDs    []string    `json:"ruleIDs,omitempty"`
+	Reason     string      `json:"reason"`
+	OrphanedAt metav1.Time `json:"orphanedAt"`
+	// DeletionPolicy is what the resource wanted done with its service
+	DeletionPolicy v1.DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// keepsService reports whether the service was meant to outlive its resource, so it isn't garbage
+func (o *OrphanRecord) keepsService() bool {
+	return o.DeletionPolicy == v1.DeletionPolicyRetain || o.DeletionPolicy == v1.DeletionPolicyDisable
+}
+
+// empty reports whether nothing was left behind
+func (o *OrphanRecord) empty() bool {
+	return o.ServiceID == "" && o.RulesetID == "" && len(o.RuleIDs) == 0
+}
+
+func (o *OrphanRecord) String() string {
+	parts := make([]string, 0, 3)
+	if o.ServiceID != "" {
+		parts = append(parts, "service "+o.ServiceID)
+	}
+	if len(o.RuleIDs) > 0 {
+		parts = append(parts, fmt.Sprintf("rules %s in ruleset %s", strings.Join(o.RuleIDs, ", "), o.RulesetID))
+	} else if o.RulesetID != "" {
+		parts = append(parts, "ruleset "+o.RulesetID)
+	}
+	return strings.Join(parts, " and ")
+}
+
+// OrphanLedger keeps OrphanRecords in a ConfigMap, so what was left behind can be cleaned up later
+type OrphanLedger struct {
+	// Client shouldn't be cached, so the operator doesn't need to watch every ConfigMap
+	Client    client.Client
+	Namespace string
+	Name      string
+}
+
+// Record adds the record to the ledger, creating its ConfigMap if needed
+func (l *OrphanLedger) Record(ctx context.Context, uid types.UID, record OrphanRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := strings.ToLower(fmt.Sprintf("%s.%s.%s.%s", record.Kind, record.Namespace, record.Name, uid))
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var ledger corev1.ConfigMap
+		err := l.Client.Get(ctx, types.NamespacedName{Namespace: l.Namespace, Name: l.Name}, &ledger)
+		if errors.IsNotFound(err) {
+			ledger = corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: l.Namespace, Name: l.Name},
+				Data:       map[string]string{key: string(data)},
+			}
+			return l.Client.Create(ctx, &ledger)
+		} else if err != nil {
+			return err
+		}
+		if ledger.Data == nil {
+			ledger.Data = make(map[string]string)
+		}
+		ledger.Data[key] = string(data)
+		return l.Client.Update(ctx, &ledger)
+	})
+}
+
+// Records lists the records in the ledger
+func (l *OrphanLedger) Records(ctx context.Context) ([]OrphanRecord, error) {
+	var ledger corev1.ConfigMap
+	err := l.Client.Get(ctx, types.NamespacedName{Namespace: l.Namespace, Name: l.Name}, &ledger)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	records := make([]OrphanRecord, 0, len(ledger.Data))
+	for key, data := range ledger.Data {
+		var record OrphanRecord
+		if err = json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, fmt.Errorf("Unable to read entry %s of ConfigMap %s/%s: %v", key, l.Namespace, l.Name, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// forceRemovalReason explains why a resource's finalizer should be removed even though cleaning
+// up failed, or returns "" if it shouldn't. A zero timeout only honors the annotation.
+func forceRemovalReason(meta *metav1.ObjectMeta, timeout time.Duration, now time.Time) string {
+	if meta.Annotations[v1.ForceRemoveFinalizerAnnotation] == "true" {
+		return fmt.Sprintf("the %s annotation is set", v1.ForceRemoveFinalizerAnnotation)
+	}
+	if timeout > 0 && meta.DeletionTimestamp != nil && now.Sub(meta.DeletionTimestamp.Time) > timeout {
+		return fmt.Sprintf("cleanup kept failing for %s", timeout)
+	}
+	return ""
+}
+
+// resultForCleanupError is resultForError for a failed cleanup, which is also requeued for when the
+// cleanup timeout is up. A permanent error isn't retried otherwise, and the timeout would never fire.
+// A transient error is returned, and retried with backoff anyway.
+func resultForCleanupError(err error, retryAfter time.Duration, meta *metav1.ObjectMeta, timeout time.Duration, now time.Time) (ctrl.Result, error) {
+	result, err := resultForError(err, retryAfter)
+	if timeout <= 0 || meta.DeletionTimestamp == nil {
+		return result, err
+	}
+	untilTimeout := meta.DeletionTimestamp.Add(timeout).Sub(now)
+	if untilTimeout < time.Second {
+		untilTimeout = time.Second
+	}
+	if result.RequeueAfter == 0 || untilTimeout < result.RequeueAfter {
+		result.RequeueAfter = untilTimeout
+	}
+	return result, err
+}
+
+// recordOrphans reports the objects left behind by a forced finalizer removal, in an event and the ledger if there is one
+func recordOrphans(ctx context.Context, ledger *OrphanLedger, recorder record.EventRecorder, obj runtime.Object, uid types.UID, orphans OrphanRecord) error {
+	msg := fmt.Sprintf("Removing the finalizer without cleaning up, because %s", orphans.Reason)
+	if !orphans.empty() {
+		msg += fmt.Sprintf(". Left %s in pagerduty", orphans.String())
+	}
+	logger.Info(msg, "namespace", orphans.Namespace, "name", orphans.Name)
+	recorder.Event(obj, "Warning", "FinalizerForceRemoved", msg)
+
+	if ledger == nil || orphans.empty() {
+		return nil
+	}
+	if err := ledger.Record(ctx, uid, orphans); err != nil {
+		return fmt.Errorf("Unable to record the orphaned pagerduty objects in ConfigMap %s/%s: %v", ledger.Namespace, ledger.Name, err)
+	}
+	return nil
+}
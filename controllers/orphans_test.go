@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
+)
+
+// failingMock fails every call, like pagerduty does when it's down or the API key was revoked
+type failingMock struct {
+	err error
+}
+
+var _ pdhelpers.PagerdutyClientInterface = &failingMock{}
+
+func newUnreachableMock() *failingMock {
+	return &failingMock{err: fmt.Errorf("Error calling the API endpoint: dial tcp: i/o timeout")}
+}
+
+func (m *failingMock) GetEscalationPolicy(id string, opt *pagerduty.GetEscalationPolicyOptions) (*pagerduty.EscalationPolicy, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) ListPriorities() (*pagerduty.Priorities, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) CreateRuleset(r *pagerduty.Ruleset) (*pagerduty.Ruleset, *http.Response, error) {
+	return nil, nil, m.err
+}
+
+func (m *failingMock) DeleteRuleset(id string) error {
+	return m.err
+}
+
+func (m *failingMock) GetRuleset(id string) (*pagerduty.Ruleset, *http.Response, error) {
+	return nil, nil, m.err
+}
+
+func (m *failingMock) ListRulesets() (*pagerduty.ListRulesetsResponse, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) UpdateRuleset(r *pagerduty.Ruleset) (*pagerduty.Ruleset, *http.Response, error) {
+	return nil, nil, m.err
+}
+
+func (m *failingMock) CreateRulesetRule(rulesetID string, rule *pagerduty.RulesetRule) (*pagerduty.RulesetRule, *http.Response, error) {
+	return nil, nil, m.err
+}
+
+func (m *failingMock) DeleteRulesetRule(rulesetID string, ruleID string) error {
+	return m.err
+}
+
+func (m *failingMock) GetRulesetRule(rulesetID string, ruleID string) (*pagerduty.RulesetRule, *http.Response, error) {
+	return nil, nil, m.err
+}
+
+func (m *failingMock) ListRulesetRules(rulesetID string) (*pagerduty.ListRulesetRulesResponse, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) UpdateRulesetRule(rulesetID string, ruleID string, rule *pagerduty.RulesetRule) (*pagerduty.RulesetRule, *http.Response, error) {
+	return nil, nil, m.err
+}
+
+func (m *failingMock) CreateService(service pagerduty.Service) (*pagerduty.Service, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) DeleteService(id string) error {
+	return m.err
+}
+
+func (m *failingMock) GetService(id string, opts *pagerduty.GetServiceOptions) (*pagerduty.Service, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) ListServices(o pagerduty.ListServiceOptions) (*pagerduty.ListServiceResponse, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) UpdateService(service pagerduty.Service) (*pagerduty.Service, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) ListIncidents(o pagerduty.ListIncidentsOptions) (*pagerduty.ListIncidentsResponse, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) ManageIncidents(from string, incidents []pagerduty.ManageIncidentsOptions) (*pagerduty.ListIncidentsResponse, error) {
+	return nil, m.err
+}
+
+func (m *failingMock) CreateIncidentNoteWithResponse(id string, note pagerduty.IncidentNote) (*pagerduty.IncidentNote, error) {
+	return nil, m.err
+}
+
+func ledgerRecords(g *GomegaWithT, c client.Client) map[string]OrphanRecord {
+	var ledger corev1.ConfigMap
+	g.Expect(c.Get(context.Background(), types.NamespacedName{Namespace: "operator", Name: "orphans"}, &ledger)).To(Succeed())
+	records := make(map[string]OrphanRecord)
+	for key, value := range ledger.Data {
+		var record OrphanRecord
+		g.Expect(json.Unmarshal([]byte(value), &record)).To(Succeed())
+		records[key] = record
+	}
+	return records
+}
+
+// TestForceRemoveFinalizer ensures stuck resources can be let go, without losing track of what they left behind
+func TestForceRemoveFinalizer(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	deleted := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "api", Namespace: "default", UID: "UID1",
+			DeletionTimestamp: &deleted, Finalizers: []string{finalizerKey},
+		},
+		Status: v1.PagerdutyServiceStatus{ServiceID: "SVC", RuleIDs: []string{"RULE"}, RulesetID: "RS1"},
+	}
+	r := newTestReconciler(kubeService)
+	c := r.Client
+	r.PdClient = newUnreachableMock()
+	r.Orphans = &OrphanLedger{Client: c, Namespace: "operator", Name: "orphans"}
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+
+	// by default the finalizer stays until cleanup works
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).To(HaveOccurred())
+	var stuck v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &stuck)).To(Succeed())
+	g.Expect(stuck.Finalizers).To(ContainElement(finalizerKey))
+
+	// until it has been failing for longer than the timeout
+	r.CleanupTimeout = time.Hour
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	var released v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &released)).To(Succeed())
+	g.Expect(released.Finalizers).To(BeEmpty())
+	g.Expect(<-recorder.Events).To(ContainSubstring("Left service SVC and rules RULE in ruleset RS1 in pagerduty"))
+
+	record := ledgerRecords(g, c)["pagerdutyservice.default.api.uid1"]
+	g.Expect(record.ServiceID).To(Equal("SVC"))
+	g.Expect(record.RuleIDs).To(Equal([]string{"RULE"}))
+	g.Expect(record.RulesetID).To(Equal("RS1"))
+	g.Expect(record.Reason).To(ContainSubstring("i/o timeout"))
+}
+
+// TestForceRemoveRetainedService ensures services meant to outlive their resource aren't garbage collected
+// once its finalizer is forced off, even though pagerduty was down and their marker is still on them
+func TestForceRemoveRetainedService(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	for _, policy := range []v1.DeletionPolicy{v1.DeletionPolicyRetain, v1.DeletionPolicyDisable} {
+		deleted := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		kubeService := &v1.PagerdutyService{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "api", Namespace: "default", UID: "UID1",
+				DeletionTimestamp: &deleted, Finalizers: []string{finalizerKey},
+				Annotations: map[string]string{v1.ForceRemoveFinalizerAnnotation: "true"},
+			},
+			Spec:   v1.PagerdutyServiceSpec{DeletionPolicy: policy},
+			Status: v1.PagerdutyServiceStatus{ServiceID: "SVC", RuleIDs: []string{"RULE"}, RulesetID: "RS1"},
+		}
+		r := newTestReconciler(kubeService)
+		c := r.Client
+		r.PdClient = newUnreachableMock()
+		r.ClusterID = "east"
+		r.Orphans = &OrphanLedger{Client: c, Namespace: "operator", Name: "orphans"}
+
+		name := types.NamespacedName{Name: "api", Namespace: "default"}
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+		g.Expect(err).ToNot(HaveOccurred())
+		record := ledgerRecords(g, c)["pagerdutyservice.default.api.uid1"]
+		g.Expect(record.ServiceID).To(Equal("SVC"))
+		g.Expect(record.DeletionPolicy).To(Equal(policy))
+
+		// the resource is gone once its finalizer is
+		var released v1.PagerdutyService
+		g.Expect(c.Get(ctx, name, &released)).To(Succeed())
+		g.Expect(released.Finalizers).To(BeEmpty())
+		g.Expect(c.Delete(ctx, &released)).To(Succeed())
+
+		// pagerduty is back, with the marker still on the service
+		owner := Owner{Cluster: "east", Namespace: "default", Name: "api", UID: "UID1"}
+		pdClient := &PagerdutyClientMock{
+			service: &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "SVC"}, Description: withOwnerMarker("The API", owner)},
+		}
+		gc := &GarbageCollector{Client: c, PdClient: pdClient, ClusterID: "east", RulesetID: "RS1", Orphans: r.Orphans}
+		for i := 0; i < 2; i++ {
+			orphans, err := gc.Collect(ctx)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(orphans).To(BeEmpty(), string(policy))
+		}
+		g.Expect(pdClient.service).ToNot(BeNil())
+	}
+}
+
+// TestForceRemovalReason checks when a finalizer is given up on
+func TestForceRemovalReason(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	deleted := metav1.NewTime(now.Add(-time.Minute))
+	meta := &metav1.ObjectMeta{DeletionTimestamp: &deleted}
+	g.Expect(forceRemovalReason(meta, 0, now)).To(BeEmpty())
+	g.Expect(forceRemovalReason(meta, time.Hour, now)).To(BeEmpty())
+	g.Expect(forceRemovalReason(meta, time.Second, now)).ToNot(BeEmpty())
+
+	meta.Annotations = map[string]string{v1.ForceRemoveFinalizerAnnotation: "true"}
+	g.Expect(forceRemovalReason(meta, 0, now)).To(ContainSubstring(v1.ForceRemoveFinalizerAnnotation))
+
+	// open incidents aren't waited out, but can be forced
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", DeletionTimestamp: &deleted},
+		Status:     v1.PagerdutyServiceStatus{ServiceID: "SVC"},
+	}
+	r := newTestReconciler()
+	r.CleanupTimeout = time.Second
+	blocked := &openIncidentsError{serviceID: "SVC", incidents: []pagerduty.Incident{{IncidentNumber: 1}}}
+	g.Expect(r.abandonCleanup(context.Background(), kubeService, blocked)).To(Equal(blocked))
+	kubeService.Annotations = map[string]string{v1.ForceRemoveFinalizerAnnotation: "true"}
+	g.Expect(r.abandonCleanup(context.Background(), kubeService, blocked)).To(Succeed())
+}
+
+// TestCleanupTimeoutRequeue ensures a cleanup failing for good is retried when the cleanup timeout is up,
+// even though permanent errors aren't retried otherwise
+func TestCleanupTimeoutRequeue(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	revoked := &failingMock{err: fmt.Errorf("Failed call API endpoint. HTTP response code: 401. Error: &{2006 Unauthorized []}")}
+	deleted := metav1.NewTime(time.Now().Add(-30 * time.Minute))
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "api", Namespace: "default",
+			DeletionTimestamp: &deleted, Finalizers: []string{finalizerKey},
+		},
+		Status: v1.PagerdutyServiceStatus{ServiceID: "SVC", RuleIDs: []string{"RULE"}, RulesetID: "RS1"},
+	}
+	r := newTestReconciler(kubeService)
+	r.PdClient = revoked
+	r.CleanupTimeout = time.Hour
+
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically("~", 30*time.Minute, time.Minute))
+
+	// without a timeout, there's nothing to wait for
+	r.CleanupTimeout = 0
+	result, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeZero())
+
+	ruleset := &v1.PagerdutyRuleset{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "shared", Namespace: "monitoring",
+			DeletionTimestamp: &deleted, Finalizers: []string{rulesetFinalizerKey},
+		},
+		Status: v1.PagerdutyRulesetStatus{RulesetID: "RS1", Created: true},
+	}
+	rulesetReconciler := &PagerdutyRulesetReconciler{
+		Client:          newTestReconciler(ruleset).Client,
+		Log:             ctrl.Log.WithName("test"),
+		EventRecorder:   record.NewFakeRecorder(10),
+		PagerDutyClient: revoked,
+		CleanupTimeout:  time.Hour,
+	}
+	result, err = rulesetReconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "shared", Namespace: "monitoring"}})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically("~", 30*time.Minute, time.Minute))
+}
+
+// TestForceRemoveRulesetFinalizer ensures adopted rulesets only record the catch-all they still route
+func TestForceRemoveRulesetFinalizer(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	c := newTestReconciler().Client
+	r := &PagerdutyRulesetReconciler{
+		EventRecorder: record.NewFakeRecorder(10),
+		Orphans:       &OrphanLedger{Client: c, Namespace: "operator", Name: "orphans"},
+	}
+	ruleset := &v1.PagerdutyRuleset{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "shared", Namespace: "monitoring", UID: "UID2",
+			Annotations: map[string]string{v1.ForceRemoveFinalizerAnnotation: "true"},
+		},
+		Status: v1.PagerdutyRulesetStatus{RulesetID: "RS1", CatchallRuleID: "CATCHALL"},
+	}
+	g.Expect(r.abandonCleanup(context.Background(), ruleset, fmt.Errorf("unauthorized"))).To(Succeed())
+
+	record := ledgerRecords(g, c)["pagerdutyruleset.monitoring.shared.uid2"]
+	g.Expect(record.RulesetID).To(Equal("RS1"))
+	g.Expect(record.RuleIDs).To(Equal([]string{"CATCHALL"}))
+}
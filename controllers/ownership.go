@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	"k8s.io/apimachinery/pkg/types"
+
+	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
+)
+
+// ownerMarkerPattern finds the ownership marker at the end of a service description or rule condition
+var ownerMarkerPattern = regexp.MustCompile(`\s*\[pagerduty-operator owner:([^\]]*)\]\s*$`)
+
+// Owner identifies the resource managing a pagerduty service and its rules. It's kept in a marker at
+// the end of the service's description, and in an extra condition of each rule, since the only text
+// a rule has otherwise is the note it adds to incidents, which responders read. Both can be edited
+// in the UI; a missing marker is put back by the next sync.
+type Owner struct {
+	// Cluster is the -cluster-id of the operator
+	Cluster   string
+	Namespace string
+	Name      string
+	UID       types.UID
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("%s/%s in cluster %s", o.Namespace, o.Name, o.Cluster)
+}
+
+// sameResource reports whether both owners name the same resource. A resource that was deleted
+// and created again under the same name gets a new UID, but it still owns what it left behind.
+func (o Owner) sameResource(other Owner) bool {
+	return o.Cluster == other.Cluster && o.Namespace == other.Namespace && o.Name == other.Name
+}
+
+func (o Owner) marker() string {
+	return fmt.Sprintf("[pagerduty-operator owner: cluster=%s namespace=%s name=%s uid=%s]", o.Cluster, o.Namespace, o.Name, o.UID)
+}
+
+// ownershipError keeps the operator from changing pagerduty objects another resource owns
+type ownershipError struct {
+	kind  string
+	id    string
+	owner Owner
+}
+
+func (e *ownershipError) Error() string {
+	return fmt.Sprintf("The %s %s is owned by PagerdutyService %s", e.kind, e.id, e.owner)
+}
+
+// clusterID identifies this cluster in ownership markers
+func (r *PagerdutyServiceReconciler) clusterID() string {
+	if r.ClusterID == "" {
+		return r.ClusterName
+	}
+	return r.ClusterID
+}
+
+// ownerOf returns the owner the reconciler stamps on the resource's service and rules
+func (r *PagerdutyServiceReconciler) ownerOf(kubeService *v1.PagerdutyService) Owner {
+	return Owner{Cluster: r.clusterID(), Namespace: kubeService.Namespace, Name: kubeService.Name, UID: kubeService.UID}
+}
+
+// checkOwner fails if a pagerduty object is owned by another resource. Objects without a marker
+// are free to take, and so are markers without a cluster, which the operator wrote before it had a cluster ID.
+func checkOwner(kind string, id string, text string, owner Owner) error {
+	liveOwner, owned := parseOwner(text)
+	if !owned || liveOwner.Cluster == "" || liveOwner.sameResource(owner) {
+		return nil
+	}
+	return &ownershipError{kind: kind, id: id, owner: liveOwner}
+}
+
+// parseOwner reads the ownership marker of a service description or rule condition, if it has one
+func parseOwner(text string) (Owner, bool) {
+	match := ownerMarkerPattern.FindStringSubmatch(text)
+	if match == nil {
+		return Owner{}, false
+	}
+	var owner Owner
+	for _, field := range strings.Fields(match[1]) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "cluster":
+			owner.Cluster = parts[1]
+		case "namespace":
+			owner.Namespace = parts[1]
+		case "name":
+			owner.Name = parts[1]
+		case "uid":
+			owner.UID = types.UID(parts[1])
+		}
+	}
+	return owner, true
+}
+
+// withOwnerMarker returns the text with the owner's marker at the end, replacing any other marker
+func withOwnerMarker(text string, owner Owner) string {
+	text = withoutOwnerMarker(text)
+	if text == "" {
+		return owner.marker()
+	}
+	return text + "\n\n" + owner.marker()
+}
+
+// withoutOwnerMarker returns the text without its ownership marker
+func withoutOwnerMarker(text string) string {
+	return ownerMarkerPattern.ReplaceAllString(text, "")
+}
+
+// hasOwnerMarker reports whether the text carries exactly the owner's marker
+func hasOwnerMarker(text string, owner Owner) bool {
+	liveOwner, owned := parseOwner(text)
+	return owned && liveOwner == owner
+}
+
+// withRuleOwner returns a copy of the conditions with a condition holding the owner's marker. No event
+// contains the marker, so the condition is "doesn't contain it" when all conditions must match and
+// "contains it" when any may, and it never changes what the rule matches. It checks a field another
+// condition requires, so it doesn't fail on events without that field either.
+func withRuleOwner(conditions *pagerduty.RuleConditions, owner Owner) *pagerduty.RuleConditions {
+	stamped := withoutRuleOwner(conditions)
+	if stamped == nil {
+		stamped = &pagerduty.RuleConditions{Operator: "and"}
+	}
+	operator := pdOpNotContains
+	if stamped.Operator == "or" {
+		operator = pdOpContains
+	}
+	path := firingPath
+	for _, subcondition := range stamped.RuleSubconditions {
+		if subcondition.Parameters != nil && requiresField(subcondition.Operator) {
+			path = subcondition.Parameters.Path
+			break
+		}
+	}
+	stamped.RuleSubconditions = append(stamped.RuleSubconditions, &pagerduty.RuleSubcondition{
+		Operator:   operator,
+		Parameters: &pagerduty.ConditionParameter{Path: path, Value: owner.marker()},
+	})
+	return stamped
+}
+
+// requiresField reports whether a condition with the operator only matches events that have its field
+func requiresField(operator string) bool {
+	switch operator {
+	case pdOpEquals, pdOpContains, pdOpMatches, pdOpExists:
+		return true
+	}
+	return false
+}
+
+// withoutRuleOwner returns a copy of the conditions without the ownership marker's condition
+func withoutRuleOwner(conditions *pagerduty.RuleConditions) *pagerduty.RuleConditions {
+	if conditions == nil {
+		return nil
+	}
+	stripped := &pagerduty.RuleConditions{
+		Operator:          conditions.Operator,
+		RuleSubconditions: make([]*pagerduty.RuleSubcondition, 0, len(conditions.RuleSubconditions)+1),
+	}
+	for _, subcondition := range conditions.RuleSubconditions {
+		if !isOwnerCondition(subcondition) {
+			stripped.RuleSubconditions = append(stripped.RuleSubconditions, subcondition)
+		}
+	}
+	return stripped
+}
+
+// ruleOwnerMarker returns the ownership marker in the rule's conditions, or "" if it has none
+func ruleOwnerMarker(rule *pagerduty.RulesetRule) string {
+	if rule.Conditions == nil {
+		return ""
+	}
+	for _, subcondition := range rule.Conditions.RuleSubconditions {
+		if isOwnerCondition(subcondition) {
+			return subcondition.Parameters.Value
+		}
+	}
+	return ""
+}
+
+// isOwnerCondition reports whether the condition is nothing but an ownership marker
+func isOwnerCondition(subcondition *pagerduty.RuleSubcondition) bool {
+	if subcondition == nil || subcondition.Parameters == nil {
+		return false
+	}
+	_, owned := parseOwner(subcondition.Parameters.Value)
+	return owned && strings.TrimSpace(withoutOwnerMarker(subcondition.Parameters.Value)) == ""
+}
+
+// releasePdService removes the ownership marker from a service the operator leaves behind,
+// so the garbage collector doesn't take it for an orphan
+func (r *PagerdutyServiceReconciler) releasePdService(serviceID string, owner Owner) error {
+	pdService, err := r.PdClient.GetService(serviceID, &pagerduty.GetServiceOptions{})
+	if err != nil {
+		return err
+	}
+	if _, owned := parseOwner(pdService.Description); !owned {
+		return nil
+	}
+	if err = checkOwner("service", serviceID, pdService.Description, owner); err != nil {
+		return err
+	}
+	pdService.Description = withoutOwnerMarker(pdService.Description)
+	_, err = r.PdClient.UpdateService(*pdService)
+	return err
+}
+
+// findOwnedService looks for a service created for the resource that it lost track of, like when
+// saving the service's ID failed. The services named like serviceName are searched first, which
+// includes the ones that got a suffix because the name was taken, and only then every service in
+// the account, for one renamed since. A nil service means there is none.
+func (r *PagerdutyServiceReconciler) findOwnedService(owner Owner, serviceName string) (*pagerduty.Service, error) {
+	helper := pdhelpers.ServiceHelper{ServiceClient: r.PdClient}
+	for _, query := range []string{serviceName, ""} {
+		services, err := helper.SearchServices(query)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range services {
+			if hasOwnerMarker(services[idx].Description, owner) {
+				return &services[idx], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// findLostRules lists the rules in the ruleset routing to the service that the resource lost track of
+func (r *PagerdutyServiceReconciler) findLostRules(rulesetID string, serviceID string, known []string) ([]*pagerduty.RulesetRule, error) {
+	rules, err := r.PdClient.ListRulesetRules(rulesetID)
+	if err != nil {
+		return nil, err
+	}
+	lost := make([]*pagerduty.RulesetRule, 0)
+	for _, rule := range rules.Rules {
+		if findStringInSlice(known, rule.ID) >= 0 || rule.Actions == nil || rule.Actions.Route == nil {
+			continue
+		}
+		if rule.Actions.Route.Value == serviceID {
+			lost = append(lost, rule)
+		}
+	}
+	return lost, nil
+}
+
+// claimLostRule takes the lost rule with the given conditions out of lost, if there is one.
+// Rules routing to the service that were added by hand have other conditions, and are left alone.
+func claimLostRule(lost *[]*pagerduty.RulesetRule, conditions *pagerduty.RuleConditions) *pagerduty.RulesetRule {
+	for idx, rule := range *lost {
+		if equivalentJSON(rule.Conditions, conditions) {
+			*lost = append((*lost)[:idx], (*lost)[idx+1:]...)
+			return rule
+		}
+	}
+	return nil
+}
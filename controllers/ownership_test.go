@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+// TestOwnerMarker ensures the ownership marker can be read back, and isn't mistaken for a changed description
+func TestOwnerMarker(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	owner := Owner{Cluster: "east", Namespace: "default", Name: "api", UID: "UID1"}
+	description := withOwnerMarker("The API", owner)
+	g.Expect(description).To(Equal("The API\n\n[pagerduty-operator owner: cluster=east namespace=default name=api uid=UID1]"))
+	g.Expect(withoutOwnerMarker(description)).To(Equal("The API"))
+	parsed, ok := parseOwner(description)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(parsed).To(Equal(owner))
+
+	// moving a service to another owner replaces the marker
+	moved := withOwnerMarker(description, Owner{Cluster: "west", Namespace: "default", Name: "api"})
+	g.Expect(withoutOwnerMarker(moved)).To(Equal("The API"))
+	parsed, _ = parseOwner(moved)
+	g.Expect(parsed.Cluster).To(Equal("west"))
+
+	g.Expect(withOwnerMarker("", owner)).To(Equal("[pagerduty-operator owner: cluster=east namespace=default name=api uid=UID1]"))
+	_, ok = parseOwner("The API")
+	g.Expect(ok).To(BeFalse())
+
+	live := &pagerduty.Service{Description: description, EscalationPolicy: pagerduty.EscalationPolicy{APIObject: pagerduty.APIObject{ID: "EP"}}}
+	g.Expect(serviceDrift(live, "", "The API", "EP")).To(BeEmpty())
+	g.Expect(serviceDrift(live, "", "Another API", "EP")).To(Equal([]string{"description"}))
+
+	// rules get a condition that never changes what they match
+	conditions := &pagerduty.RuleConditions{Operator: "and", RuleSubconditions: []*pagerduty.RuleSubcondition{
+		{Operator: pdOpNotExists, Parameters: &pagerduty.ConditionParameter{Path: "details.muted"}},
+		{Operator: pdOpContains, Parameters: &pagerduty.ConditionParameter{Path: firingPath, Value: "app = foo"}},
+	}}
+	stamped := withRuleOwner(conditions, owner)
+	g.Expect(stamped.RuleSubconditions).To(HaveLen(3))
+	g.Expect(conditions.RuleSubconditions).To(HaveLen(2))
+	g.Expect(stamped.RuleSubconditions[2].Operator).To(Equal(pdOpNotContains))
+	g.Expect(stamped.RuleSubconditions[2].Parameters.Path).To(Equal(firingPath))
+	g.Expect(ruleOwnerMarker(&pagerduty.RulesetRule{Conditions: stamped})).To(Equal(owner.marker()))
+	g.Expect(equivalentJSON(withoutRuleOwner(stamped), conditions)).To(BeTrue())
+	g.Expect(withoutRuleOwner(withRuleOwner(stamped, owner)).RuleSubconditions).To(HaveLen(2))
+	g.Expect(ruleOwnerMarker(&pagerduty.RulesetRule{Conditions: conditions})).To(BeEmpty())
+
+	anyOf := withRuleOwner(&pagerduty.RuleConditions{Operator: "or", RuleSubconditions: conditions.RuleSubconditions}, owner)
+	g.Expect(anyOf.RuleSubconditions[2].Operator).To(Equal(pdOpContains))
+
+	liveRule := &pagerduty.RulesetRule{Conditions: conditions}
+	g.Expect(ruleDrift(liveRule, &pagerduty.RulesetRule{Conditions: stamped})).To(BeEmpty())
+}
+
+// TestCheckOwner ensures objects are only refused when another resource's marker is on them
+func TestCheckOwner(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	owner := Owner{Cluster: "east", Namespace: "default", Name: "api", UID: "UID1"}
+	g.Expect(checkOwner("service", "SVC", "made by hand", owner)).To(Succeed())
+	g.Expect(checkOwner("service", "SVC", withOwnerMarker("", owner), owner)).To(Succeed())
+	// the same resource, created again
+	g.Expect(checkOwner("service", "SVC", withOwnerMarker("", Owner{Cluster: "east", Namespace: "default", Name: "api", UID: "UID0"}), owner)).To(Succeed())
+	// marked before the operator had a cluster ID
+	g.Expect(checkOwner("service", "SVC", withOwnerMarker("", Owner{Namespace: "default", Name: "api"}), owner)).To(Succeed())
+
+	err := checkOwner("service", "SVC", withOwnerMarker("", Owner{Cluster: "west", Namespace: "default", Name: "api"}), owner)
+	g.Expect(err).To(MatchError("The service SVC is owned by PagerdutyService default/api in cluster west"))
+	g.Expect(checkOwner("service", "WEB", withOwnerMarker("", Owner{Cluster: "east", Namespace: "default", Name: "web"}), owner)).ToNot(Succeed())
+}
+
+// TestRefuseServiceOwnedElsewhere ensures clusters sharing a pagerduty account leave each other's services alone
+func TestRefuseServiceOwnedElsewhere(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", UID: "UID1"},
+		Spec: v1.PagerdutyServiceSpec{
+			Description:      "east's api",
+			EscalationPolicy: "EP1",
+			SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+			Adopt:            &v1.ServiceAdoptionSpec{ByName: true},
+		},
+	}
+	westOwner := Owner{Cluster: "west", Namespace: "default", Name: "api", UID: "UID2"}
+	west := &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "WEST"}, Name: "api", Description: withOwnerMarker("west's api", westOwner)}
+	pdClient := &PagerdutyClientMock{service: west}
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+	r.ClusterID = "east"
+	r.ResyncInterval = 10 * time.Minute
+
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(10 * time.Minute))
+	g.Expect(pdClient.updateServiceCalled).To(BeFalse())
+	g.Expect(pdClient.rulesetRule).To(BeNil())
+
+	var reconciled v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	g.Expect(reconciled.Status.ServiceID).To(BeEmpty())
+	g.Expect(reconciled.Status.Adopted).To(BeFalse())
+	synced := FindCondition(reconciled.Status.Conditions, v1.ConditionServiceSynced)
+	g.Expect(synced.Reason).To(Equal("NotOwned"))
+
+	// a service west took over since isn't deleted along with the resource
+	reconciled.Status.ServiceID = "WEST"
+	reconciled.Spec.DeletionPolicy = v1.DeletionPolicyDelete
+	g.Expect(r.destroyPagerdutyResources(&reconciled)).To(Succeed())
+	g.Expect(pdClient.service).To(Equal(west))
+}
+
+// TestStampRuleOwner ensures rules are marked in their conditions, not in the notes they add to incidents,
+// that a marker edited away is put back without reporting drift, and that rules owned elsewhere are left alone
+func TestStampRuleOwner(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", UID: "UID1"},
+		Spec: v1.PagerdutyServiceSpec{
+			EscalationPolicy: "EP1",
+			SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+			Actions:          &v1.RuleActionsSpec{Annotate: "runbook: https://example.com/api"},
+		},
+	}
+	pdClient := &PagerdutyClientMock{}
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+	r.ClusterID = "east"
+
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	owner := Owner{Cluster: "east", Namespace: "default", Name: "api", UID: "UID1"}
+	g.Expect(hasOwnerMarker(pdClient.service.Description, owner)).To(BeTrue())
+	g.Expect(pdClient.rulesetRule.Actions.Annotate.Value).To(Equal("runbook: https://example.com/api"))
+	g.Expect(hasOwnerMarker(ruleOwnerMarker(pdClient.rulesetRule), owner)).To(BeTrue())
+	conditions, err := buildRuleConditions(&kubeService.Spec, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(equivalentJSON(withoutRuleOwner(pdClient.rulesetRule.Conditions), conditions[0])).To(BeTrue())
+
+	pdClient.rulesetRule.Conditions = withoutRuleOwner(pdClient.rulesetRule.Conditions)
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(hasOwnerMarker(ruleOwnerMarker(pdClient.rulesetRule), owner)).To(BeTrue())
+	var reconciled v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	g.Expect(reconciled.Status.LastDriftDetected).To(BeNil())
+
+	westOwner := Owner{Cluster: "west", Namespace: "default", Name: "api", UID: "UID2"}
+	west := withRuleOwner(conditions[0], westOwner)
+	pdClient.rulesetRule.Conditions = west
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pdClient.rulesetRule.Conditions).To(Equal(west))
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	synced := FindCondition(reconciled.Status.Conditions, v1.ConditionRuleSynced)
+	g.Expect(synced.Reason).To(Equal("NotOwned"))
+
+	// nor deleted along with the resource
+	g.Expect(r.deleteRoutingRules("RS1", []string{testID}, owner)).To(Succeed())
+	g.Expect(pdClient.rulesetRule).ToNot(BeNil())
+}
+
+// scanCountingMock counts how often every service in the account is listed
+type scanCountingMock struct {
+	PagerdutyClientMock
+	scans int
+}
+
+func (m *scanCountingMock) ListServices(o pagerduty.ListServiceOptions) (*pagerduty.ListServiceResponse, error) {
+	if o.Query == "" {
+		m.scans++
+	}
+	return m.PagerdutyClientMock.ListServices(o)
+}
+
+// TestRecoverLostService ensures a service whose ID was never saved is found instead of created again,
+// without listing every service in the account unless it has to
+func TestRecoverLostService(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	owner := Owner{Namespace: "default", Name: "api", UID: "UID1"}
+	for _, c := range []struct {
+		creating    string
+		serviceName string
+		recovered   bool
+		scans       int
+	}{
+		// the name was taken, so it got a suffix
+		{creating: createService, serviceName: "api-default", recovered: true},
+		// renamed in the UI since
+		{creating: createService, serviceName: "Payments", recovered: true, scans: 1},
+		// nothing was being created, so there's nothing to look for
+		{serviceName: "api-default"},
+	} {
+		kubeService := &v1.PagerdutyService{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", UID: "UID1"},
+			Spec: v1.PagerdutyServiceSpec{
+				EscalationPolicy: "EP1",
+				SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+			},
+			Status: v1.PagerdutyServiceStatus{Creating: c.creating},
+		}
+		pdClient := &scanCountingMock{PagerdutyClientMock: PagerdutyClientMock{
+			service: &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "LOST"}, Name: c.serviceName, Description: withOwnerMarker("", owner)},
+		}}
+		r := newTestReconciler(kubeService)
+		r.PdClient = pdClient
+		recorder := r.EventRecorder.(*record.FakeRecorder)
+
+		name := types.NamespacedName{Name: "api", Namespace: "default"}
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pdClient.scans).To(Equal(c.scans), "%+v", c)
+
+		var reconciled v1.PagerdutyService
+		g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+		g.Expect(reconciled.Status.Creating).To(BeEmpty())
+		if c.recovered {
+			g.Expect(<-recorder.Events).To(ContainSubstring("Found service " + c.serviceName + " (ID: LOST) created earlier"))
+			g.Expect(reconciled.Status.ServiceID).To(Equal("LOST"))
+			g.Expect(reconciled.Status.Adopted).To(BeFalse())
+		} else {
+			g.Expect(reconciled.Status.ServiceID).To(Equal(testID))
+		}
+	}
+}
+
+// TestRecoverLostRule ensures a rule whose ID was never saved is found instead of created again,
+// but rules added to the service by hand are left alone
+func TestRecoverLostRule(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spec := v1.PagerdutyServiceSpec{
+		EscalationPolicy: "EP1",
+		SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+	}
+	conditions, err := buildRuleConditions(&spec, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	handmade := &pagerduty.RuleConditions{Operator: "and", RuleSubconditions: []*pagerduty.RuleSubcondition{{
+		Operator:   pdOpContains,
+		Parameters: &pagerduty.ConditionParameter{Path: firingPath, Value: "team = payments"},
+	}}}
+
+	for _, c := range []struct {
+		conditions *pagerduty.RuleConditions
+		ruleID     string
+	}{
+		{conditions: withRuleOwner(conditions[0], Owner{Namespace: "default", Name: "api", UID: "UID1"}), ruleID: "LOSTRULE"},
+		{conditions: handmade, ruleID: testID},
+	} {
+		kubeService := &v1.PagerdutyService{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", UID: "UID1"},
+			Spec:       spec,
+			Status:     v1.PagerdutyServiceStatus{ServiceID: "SVC", RulesetID: "RS1", Creating: createRule},
+		}
+		route := &pagerduty.RuleActions{Route: &pagerduty.RuleActionParameter{Value: "SVC"}}
+		pdClient := &PagerdutyClientMock{
+			service:     &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "SVC"}, Name: "api", Description: withOwnerMarker("", Owner{Namespace: "default", Name: "api", UID: "UID1"})},
+			rulesetRule: &pagerduty.RulesetRule{ID: "LOSTRULE", Conditions: c.conditions, Actions: route},
+		}
+		r := newTestReconciler(kubeService)
+		r.PdClient = pdClient
+
+		name := types.NamespacedName{Name: "api", Namespace: "default"}
+		_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		var reconciled v1.PagerdutyService
+		g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+		g.Expect(reconciled.Status.RuleIDs).To(Equal([]string{c.ruleID}))
+		g.Expect(reconciled.Status.Creating).To(BeEmpty())
+	}
+}
+
+// crashingClient crashes the operator when the rules are about to be reordered,
+// which is after everything was created but before the resource is updated
+type crashingClient struct {
+	client.Client
+}
+
+func (c *crashingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	panic("crashed")
+}
+
+// TestSaveCreatedIDs ensures the IDs of created objects are saved before anything else can fail
+func TestSaveCreatedIDs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Spec: v1.PagerdutyServiceSpec{
+			EscalationPolicy: "EP1",
+			SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+		},
+	}
+	pdClient := &PagerdutyClientMock{}
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+	r.Client = &crashingClient{r.Client}
+
+	name := types.NamespacedName{Name: "api", Namespace: "default"}
+	g.Expect(func() { _, _ = r.Reconcile(ctrl.Request{NamespacedName: name}) }).To(Panic())
+
+	var reconciled v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	g.Expect(reconciled.Status.ServiceID).To(Equal(testID))
+	g.Expect(reconciled.Status.RuleIDs).To(Equal([]string{testID}))
+}
@@ -19,10 +19,14 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"time"
 
 	pagerduty "github.com/PagerDuty/go-pagerduty"
 	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -34,6 +38,9 @@ import (
 
 const rulesetFinalizerKey = "pagerdutyruleset.core.strateos.com"
 
+// how long to wait for a referenced PagerdutyService to get its pagerduty service
+const catchallRefRequeueDelay = 30 * time.Second
+
 type PagerdutyReconcilerOptions struct {
 	CatchallService string
 }
@@ -44,8 +51,13 @@ type PagerdutyRulesetReconciler struct {
 	Log             logr.Logger
 	Scheme          *runtime.Scheme
 	EventRecorder   record.EventRecorder
-	PagerDutyClient pdhelpers.RulesetClient
+	PagerDutyClient pdhelpers.RulesetManagerClient
 	Options         PagerdutyReconcilerOptions
+
+	// CleanupTimeout is how long cleanup may fail before the finalizer is removed anyway. Zero waits forever.
+	CleanupTimeout time.Duration
+	// Orphans records the pagerduty objects left behind when a finalizer is removed without cleaning up
+	Orphans *OrphanLedger
 }
 
 // +kubebuilder:rbac:groups=core.strateos.com,resources=pagerdutyrulesets,verbs=get;list;watch;create;update;patch;delete
@@ -68,6 +80,9 @@ func (r *PagerdutyRulesetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, e
 		EnsureFinalizerExists(&kubeRuleset.ObjectMeta, rulesetFinalizerKey)
 	} else {
 		err = r.CleanupResources(&kubeRuleset)
+		if err != nil {
+			err = r.abandonCleanup(ctx, &kubeRuleset, err)
+		}
 		if err == nil {
 			log.Info("Cleanup Successful")
 			EnsureFinalizerRemoved(&kubeRuleset.ObjectMeta, rulesetFinalizerKey)
@@ -76,20 +91,28 @@ func (r *PagerdutyRulesetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, e
 		} else {
 			msg := fmt.Sprintf("Cleanup error: %v", err.Error())
 			r.EventRecorder.Event(&kubeRuleset, "Warning", "CleanupFail", msg)
-			return ctrl.Result{Requeue: true}, err
+			SetCondition(&kubeRuleset.Status.Conditions, v1.ConditionDeleting, metav1.ConditionTrue, errorReason(err, "CleanupFailed"), msg, kubeRuleset.Generation)
+			SetCondition(&kubeRuleset.Status.Conditions, v1.ConditionReady, metav1.ConditionFalse, "Deleting", "", kubeRuleset.Generation)
+			r.updateStatus(ctx, &kubeRuleset)
+			return resultForCleanupError(err, 0, &kubeRuleset.ObjectMeta, r.CleanupTimeout, time.Now())
 		}
 	}
+	conditions := &kubeRuleset.Status.Conditions
+	generation := kubeRuleset.Generation
+	SetCondition(conditions, v1.ConditionDeleting, metav1.ConditionFalse, "NotDeleting", "", generation)
 
 	var pdRuleset *pagerduty.Ruleset
 	var created bool
 
 	if kubeRuleset.Status.RulesetID == "" {
-		helper := pdhelpers.RulesetHelper{RulesetClient: r.PagerDutyClient}
+		helper := pdhelpers.RulesetHelper{RulesetClient: r.PagerDutyClient, RulesetRuleClient: r.PagerDutyClient}
 		pdRuleset, created, err = helper.AdoptOrCreateRuleset(kubeRuleset.Name)
 		if err != nil {
 			msg := fmt.Sprintf("Unable to create ruleset: %v", err.Error())
 			r.EventRecorder.Event(&kubeRuleset, "Warning", "CreateRuleset", msg)
-			return ctrl.Result{Requeue: true}, err
+			SetCondition(conditions, v1.ConditionReady, metav1.ConditionFalse, errorReason(err, "CreateFailed"), msg, generation)
+			r.updateStatus(ctx, &kubeRuleset)
+			return resultForError(err, 0)
 		}
 
 		var adopedOrCreated string
@@ -102,12 +125,23 @@ func (r *PagerdutyRulesetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, e
 		r.EventRecorder.Event(&kubeRuleset, "Normal", "CreateRuleset", msg)
 	} else {
 		rulesetID := kubeRuleset.Status.RulesetID
-		pdRuleset, _, err = r.PagerDutyClient.GetRuleset(rulesetID)
-		if err != nil {
+		var resp *http.Response
+		pdRuleset, resp, err = r.PagerDutyClient.GetRuleset(rulesetID)
+		if apiErr := pdhelpers.Classify(resp, err); apiErr != nil && apiErr.Class == pdhelpers.ErrorNotFound {
+			// deleted in pagerduty, so forget about it and adopt or create it again
+			msg := fmt.Sprintf("Ruleset %s was deleted in pagerduty, recreating it", rulesetID)
+			r.EventRecorder.Event(&kubeRuleset, "Warning", "Recreated", msg)
+			kubeRuleset.Status = v1.PagerdutyRulesetStatus{Conditions: kubeRuleset.Status.Conditions}
+			SetCondition(conditions, v1.ConditionReady, metav1.ConditionFalse, string(apiErr.Class), msg, generation)
+			r.updateStatus(ctx, &kubeRuleset)
+			return ctrl.Result{Requeue: true}, nil
+		} else if apiErr != nil {
 			msg := fmt.Sprintf("Unable to fetch ruleset %s", rulesetID)
 			r.EventRecorder.Event(&kubeRuleset, "Warning", "FetchPDRuleset", msg)
 			r.Log.V(1).Info(msg)
-			return ctrl.Result{Requeue: true}, err
+			SetCondition(conditions, v1.ConditionReady, metav1.ConditionFalse, errorReason(apiErr, "FetchFailed"), msg, generation)
+			r.updateStatus(ctx, &kubeRuleset)
+			return resultForError(apiErr, 0)
 		}
 	}
 
@@ -116,27 +150,162 @@ func (r *PagerdutyRulesetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, e
 		kubeRuleset.Status.Created = true
 	}
 
+	// the ruleset ID is saved even if the catch-all rule can't be set up yet
+	result, catchallErr := r.reconcileCatchall(ctx, &kubeRuleset)
+	if catchallErr != nil {
+		r.EventRecorder.Event(&kubeRuleset, "Warning", "CatchallRule", catchallErr.Error())
+		SetConditionFromError(conditions, v1.ConditionRuleSynced, catchallErr, errorReason(catchallErr, "SyncFailed"), generation)
+	} else if result.RequeueAfter == 0 {
+		// not waiting on a catch-all service, so the rule is where it should be
+		SetCondition(conditions, v1.ConditionRuleSynced, metav1.ConditionTrue, "Synced", "", generation)
+	}
+	SetReadyCondition(conditions, generation, v1.ConditionRuleSynced)
+
+	// Update returns the stored resource, without the status we've worked out
+	status := kubeRuleset.Status.DeepCopy()
 	err = r.Client.Update(ctx, &kubeRuleset)
 	if err != nil {
 		r.EventRecorder.Event(&kubeRuleset, "Warning", "CreateRuleset", err.Error())
 		return ctrl.Result{Requeue: true}, err
 	}
+	kubeRuleset.Status = *status
+	if err = r.updateStatus(ctx, &kubeRuleset); err != nil {
+		r.EventRecorder.Event(&kubeRuleset, "Warning", "CreateRuleset", err.Error())
+		return ctrl.Result{Requeue: true}, err
+	}
+	if catchallErr != nil {
+		return resultForError(catchallErr, 0)
+	}
 
+	return result, nil
+}
+
+func (r *PagerdutyRulesetReconciler) updateStatus(ctx context.Context, kubeRuleset *v1.PagerdutyRuleset) error {
+	kubeRuleset.Status.ObservedGeneration = kubeRuleset.Generation
+	err := r.Status().Update(ctx, kubeRuleset)
+	if err != nil {
+		r.Log.Error(err, "Failed to update the PagerdutyRuleset status", "ruleset", kubeRuleset.Name)
+	}
+	return err
+}
+
+// reconcileCatchall routes the ruleset's catch-all rule to the configured service,
+// or back to suppressing events once no service is configured.
+func (r *PagerdutyRulesetReconciler) reconcileCatchall(ctx context.Context, kubeRuleset *v1.PagerdutyRuleset) (ctrl.Result, error) {
+	serviceID, err := r.resolveCatchallService(ctx, kubeRuleset)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if serviceID == "" && kubeRuleset.Spec.CatchallServiceRef != nil {
+		// the referenced PagerdutyService hasn't been reconciled yet
+		r.Log.V(1).Info("Waiting for catch-all PagerdutyService", "ref", kubeRuleset.Spec.CatchallServiceRef)
+		msg := fmt.Sprintf("Waiting for PagerdutyService %s to create its service", kubeRuleset.Spec.CatchallServiceRef.Name)
+		SetCondition(&kubeRuleset.Status.Conditions, v1.ConditionRuleSynced, metav1.ConditionFalse, "WaitingForService", msg, kubeRuleset.Generation)
+		return ctrl.Result{RequeueAfter: catchallRefRequeueDelay}, nil
+	}
+	if serviceID == "" && kubeRuleset.Status.CatchallRuleID == "" {
+		return ctrl.Result{}, nil // never touched the catch-all rule, so leave it as it is
+	}
+	if serviceID == kubeRuleset.Status.CatchallServiceID && kubeRuleset.Status.CatchallRuleID != "" {
+		return ctrl.Result{}, nil
+	}
+
+	helper := pdhelpers.RulesetHelper{RulesetClient: r.PagerDutyClient, RulesetRuleClient: r.PagerDutyClient}
+	rule, err := helper.SetCatchallRoute(kubeRuleset.Status.RulesetID, serviceID)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("Unable to update catch-all rule: %v", err)
+	}
+
+	if serviceID == "" {
+		kubeRuleset.Status.CatchallServiceID = ""
+		kubeRuleset.Status.CatchallRuleID = ""
+		r.EventRecorder.Event(kubeRuleset, "Normal", "CatchallRule", "Catch-all rule suppresses unmatched events")
+		return ctrl.Result{}, nil
+	}
+	kubeRuleset.Status.CatchallServiceID = serviceID
+	kubeRuleset.Status.CatchallRuleID = rule.ID
+	msg := fmt.Sprintf("Catch-all rule %s routes to service %s", rule.ID, serviceID)
+	r.EventRecorder.Event(kubeRuleset, "Normal", "CatchallRule", msg)
 	return ctrl.Result{}, nil
 }
 
+// resolveCatchallService finds the ID of the catch-all service. An empty ID means there is none,
+// or that the referenced PagerdutyService doesn't have a pagerduty service yet.
+func (r *PagerdutyRulesetReconciler) resolveCatchallService(ctx context.Context, kubeRuleset *v1.PagerdutyRuleset) (string, error) {
+	spec := kubeRuleset.Spec
+	switch {
+	case spec.CatchallService != "" && spec.CatchallServiceRef != nil:
+		return "", fmt.Errorf("only one of catchallService and catchallServiceRef may be set")
+	case spec.CatchallService != "":
+		helper := pdhelpers.ServiceHelper{ServiceClient: r.PagerDutyClient}
+		service, err := helper.GetServiceByName(spec.CatchallService)
+		if err != nil {
+			return "", err
+		}
+		return service.ID, nil
+	case spec.CatchallServiceRef != nil:
+		ref := types.NamespacedName{Name: spec.CatchallServiceRef.Name, Namespace: spec.CatchallServiceRef.Namespace}
+		if ref.Namespace == "" {
+			ref.Namespace = kubeRuleset.Namespace
+		}
+		var kubeService v1.PagerdutyService
+		if err := r.Get(ctx, ref, &kubeService); err != nil {
+			return "", fmt.Errorf("Unable to fetch catch-all PagerdutyService %s: %v", ref, err)
+		}
+		return kubeService.Status.ServiceID, nil
+	}
+	return "", nil
+}
+
 func (r *PagerdutyRulesetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.PagerdutyRuleset{}).
 		Complete(r)
 }
 
+// abandonCleanup removes the finalizer after a failed cleanup when forced to, by the annotation or
+// the cleanup timeout, recording the pagerduty objects left behind. Otherwise it returns cleanupErr.
+func (r *PagerdutyRulesetReconciler) abandonCleanup(ctx context.Context, ruleset *v1.PagerdutyRuleset, cleanupErr error) error {
+	reason := forceRemovalReason(&ruleset.ObjectMeta, r.CleanupTimeout, time.Now())
+	if reason == "" {
+		return cleanupErr
+	}
+	orphans := OrphanRecord{
+		Kind:       "PagerdutyRuleset",
+		Namespace:  ruleset.Namespace,
+		Name:       ruleset.Name,
+		Reason:     fmt.Sprintf("%s: %v", reason, cleanupErr),
+		OrphanedAt: metav1.Now(),
+	}
+	if ruleset.Status.Created {
+		orphans.RulesetID = ruleset.Status.RulesetID
+	} else if ruleset.Status.CatchallRuleID != "" {
+		// an adopted ruleset stays anyway, but its catch-all still routes to our service
+		orphans.RulesetID = ruleset.Status.RulesetID
+		orphans.RuleIDs = []string{ruleset.Status.CatchallRuleID}
+	}
+	return recordOrphans(ctx, r.Orphans, r.EventRecorder, ruleset, ruleset.UID, orphans)
+}
+
 func (r *PagerdutyRulesetReconciler) CleanupResources(ruleset *v1.PagerdutyRuleset) error {
 	rulesetID := ruleset.Status.RulesetID
 	if rulesetID == "" {
 		return nil // no ruleset to clean up
 	} else if !ruleset.Status.Created {
-		return nil // leave adopted rulesets alone, for safety
+		// leave adopted rulesets alone, for safety. We only undo our catch-all route.
+		if ruleset.Status.CatchallRuleID == "" {
+			return nil
+		}
+		helper := pdhelpers.RulesetHelper{RulesetClient: r.PagerDutyClient, RulesetRuleClient: r.PagerDutyClient}
+		_, err := helper.SetCatchallRoute(rulesetID, "")
+		if pdhelpers.IsNotFound(err) {
+			return nil // the ruleset is already gone
+		}
+		return err
+	}
+	err := r.PagerDutyClient.DeleteRuleset(rulesetID)
+	if pdhelpers.IsNotFound(err) {
+		return nil
 	}
-	return r.PagerDutyClient.DeleteRuleset(rulesetID)
+	return err
 }
@@ -124,6 +124,35 @@ var _ = Describe("PagerdutyRuleset Controller", func() {
 		})
 
 	})
+
+	When("Setting a catch-all service", func() {
+		It("Routes unmatched events to it", func() {
+			service, err := fakeServiceClient.CreateService(pagerduty.Service{Name: "catchall-service"})
+			Expect(err).NotTo(HaveOccurred())
+
+			catchallRuleset := newTestK8sRuleset("with-catchall")
+			catchallRuleset.Spec.CatchallService = service.Name
+			err = k8sClient.Create(ctx, &catchallRuleset)
+			Expect(err).ToNot(HaveOccurred())
+			namespacedName := types.NamespacedName{
+				Namespace: catchallRuleset.Namespace,
+				Name:      catchallRuleset.Name,
+			}
+
+			Eventually(func() string {
+				_ = k8sClient.Get(ctx, namespacedName, &catchallRuleset)
+				return catchallRuleset.Status.CatchallServiceID
+			}).Should(Equal(service.ID))
+
+			rule, _, err := fakeRulesetClient.GetRulesetRule(catchallRuleset.Status.RulesetID, catchallRuleset.Status.CatchallRuleID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rule.CatchAll).To(BeTrue())
+			Expect(rule.Actions.Route.Value).To(Equal(service.ID))
+
+			err = k8sClient.Delete(ctx, &catchallRuleset)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
 })
 
 func newTestK8sRuleset(name string) v1.PagerdutyRuleset {
@@ -21,22 +21,35 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"text/template"
 	"time"
 
 	pagerduty "github.com/PagerDuty/go-pagerduty"
-	"github.com/dchest/uniuri"
 	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
 
 	corev1 "k8s.io/api/core/v1"
 )
 
 const finalizerKey = "pagerdutyservice.core.strateos.com"
 
+// how long to wait for a referenced PagerdutyRuleset to get its pagerduty ruleset
+const rulesetRefRequeueDelay = 30 * time.Second
+
+// what Status.Creating says is being created
+const (
+	createService = "service"
+	createRule    = "rule"
+)
+
 // PagerdutyServiceReconciler reconciles a PagerdutyService object
 type PagerdutyServiceReconciler struct {
 	client.Client
@@ -44,14 +57,51 @@ type PagerdutyServiceReconciler struct {
 	Scheme *runtime.Scheme
 
 	PdClient      ServiceReconcilerPagerdutyInterface
-	RulesetID     string
+	RulesetID     string // used by PagerdutyServices without a rulesetRef
 	ServicePrefix string // append to service names
+
+	// ServiceNameTemplate renders the names of services without their own template.
+	// Defaults to DefaultServiceNameTemplate.
+	ServiceNameTemplate *template.Template
+	// ClusterName is available to service name templates as .Cluster
+	ClusterName string
+	// ClusterID goes in the ownership markers of the services and rules the operator manages,
+	// so clusters sharing a pagerduty account leave each other's alone. Defaults to ClusterName.
+	ClusterID string
+	// DefaultDeletionPolicy is used by PagerdutyServices that don't specify a deletion policy,
+	// except adopted ones, which are retained. Defaults to Delete.
+	DefaultDeletionPolicy v1.DeletionPolicy
+
+	// DefaultOpenIncidentsPolicy is used by PagerdutyServices that don't specify what to do
+	// about open incidents when they're deleted. Defaults to Block.
+	DefaultOpenIncidentsPolicy v1.OpenIncidentsPolicy
+	// FromEmail is the pagerduty user incidents are resolved as
+	FromEmail string
+
+	// CleanupTimeout is how long cleanup may fail before the finalizer is removed anyway. Zero waits forever.
+	CleanupTimeout time.Duration
+	// Orphans records the pagerduty objects left behind when a finalizer is removed without cleaning up
+	Orphans *OrphanLedger
+
+	// Renames spreads out renaming services when their names change. Nil renames them right away.
+	Renames *RenameMigration
+
+	// DefaultSourceProfile is used by PagerdutyServices that don't specify a source profile
+	DefaultSourceProfile v1.SourceProfile
+
+	// ResyncInterval is how often synced services are checked for changes made outside the operator.
+	// Zero disables the periodic resync.
+	ResyncInterval time.Duration
+
+	EventRecorder record.EventRecorder
 }
 
 var logger = ctrl.Log.WithName("pagerdutyServiceReconciler")
 
 // +kubebuilder:rbac:groups=core.strateos.com,resources=pagerdutyservices,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core.strateos.com,resources=pagerdutyservices/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *PagerdutyServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -68,17 +118,37 @@ func (r *PagerdutyServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, e
 	}
 	spec := &kubeService.Spec
 	status := &kubeService.Status
+	generation := kubeService.Generation
+
+	// differences between pagerduty and an unchanged, synced spec were made outside the operator
+	wasSynced := status.ObservedGeneration == generation && isConditionTrue(status.Conditions, v1.ConditionReady)
 
 	if kubeService.DeletionTimestamp.IsZero() {
 		EnsureFinalizerExists(&kubeService.ObjectMeta, finalizerKey)
 	} else {
 		logger.Info("Resource is marked for deletion. Cleaning up.")
 		err = r.destroyPagerdutyResources(&kubeService)
+		if err != nil {
+			err = r.abandonCleanup(ctx, &kubeService, err)
+		}
 		if err == nil {
 			// when everything is cleaned up, remove the finalizer, so k8s can delete the resource
 			logger.Info("Cleanup succesful")
+			if r.Renames != nil {
+				r.Renames.Done(req.NamespacedName, false)
+			}
 			EnsureFinalizerRemoved(&kubeService.ObjectMeta, finalizerKey)
 			err = r.Update(ctx, kubeService.DeepCopyObject())
+		} else if _, blocked := err.(*openIncidentsError); blocked {
+			logger.Info("Deletion is blocked by open incidents", "error", err.Error())
+			r.EventRecorder.Event(&kubeService, "Warning", "OpenIncidents", err.Error())
+			SetCondition(&status.Conditions, v1.ConditionDeleting, metav1.ConditionTrue, "OpenIncidents", err.Error(), generation)
+			r.UpdateStatus(&kubeService, err)
+			return ctrl.Result{RequeueAfter: openIncidentsRequeueDelay}, nil
+		} else {
+			SetCondition(&status.Conditions, v1.ConditionDeleting, metav1.ConditionTrue, errorReason(err, "CleanupFailed"), err.Error(), generation)
+			r.UpdateStatus(&kubeService, err)
+			return resultForCleanupError(err, r.ResyncInterval, &kubeService.ObjectMeta, r.CleanupTimeout, time.Now())
 		}
 		return ctrl.Result{}, err
 	}
@@ -86,6 +156,7 @@ func (r *PagerdutyServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, e
 	escalationPolicyID, err := r.GetEscalationPolicyID(&kubeService)
 	if err != nil {
 		logger.Info("Could not resolve the escalation policy ID. Will retry.", "pdService", kubeService.Name)
+		SetConditionFromError(&status.Conditions, v1.ConditionEscalationPolicyResolved, err, "PolicyIDNotFound", generation)
 		r.UpdateStatus(&kubeService, err)
 		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 30}, nil
 	}
@@ -94,146 +165,605 @@ func (r *PagerdutyServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, e
 	if escalationPolicy == nil {
 		delay := time.Second * 30
 		logger.Error(err, "Can't find the escalation policy. Will retry.", "policyID", spec.EscalationPolicy, "delay", delay)
-		r.UpdateStatus(&kubeService, fmt.Errorf("Unable to get the escaltionPolciy %s from Pagerduty", escalationPolicyID))
+		reason := "PolicyNotFound"
+		if err != nil && !pdhelpers.IsNotFound(err) {
+			reason = errorReason(err, reason)
+		}
+		err = fmt.Errorf("Unable to get the escaltionPolciy %s from Pagerduty", escalationPolicyID)
+		SetConditionFromError(&status.Conditions, v1.ConditionEscalationPolicyResolved, err, reason, generation)
+		r.UpdateStatus(&kubeService, err)
 		return ctrl.Result{Requeue: true, RequeueAfter: delay}, nil
 	}
+	SetCondition(&status.Conditions, v1.ConditionEscalationPolicyResolved, metav1.ConditionTrue, "PolicyFound", "", generation)
+
+	serviceName, err := r.desiredServiceName(&kubeService)
+	if err != nil {
+		// nothing to retry until the spec or the operator's flags change
+		logger.Info("Invalid service name", "error", err.Error())
+		SetConditionFromError(&status.Conditions, v1.ConditionServiceSynced, err, "InvalidServiceName", generation)
+		r.UpdateStatus(&kubeService, err)
+		return ctrl.Result{}, nil
+	}
+
+	if err = r.checkOpenIncidentsPolicy(&kubeService); err != nil {
+		// nothing to retry until the spec or the operator's flags change
+		logger.Info("Invalid open incidents policy", "error", err.Error())
+		SetConditionFromError(&status.Conditions, v1.ConditionServiceSynced, err, "InvalidOpenIncidentsPolicy", generation)
+		r.UpdateStatus(&kubeService, err)
+		return ctrl.Result{}, nil
+	}
 
+	owner := r.ownerOf(&kubeService)
 	var serviceExists bool
 	if status.ServiceID != "" { // Service might already exist
 		logger.Info("Fetching service from pagerduty", "serviceId", status.ServiceID, "serviceName", status.ServiceName)
 		pdService, err = r.PdClient.GetService(status.ServiceID, &pagerduty.GetServiceOptions{})
+		if pdhelpers.IsNotFound(err) {
+			r.recordRecreation(&kubeService, "service", status.ServiceID)
+			status.ServiceID = ""
+			status.ServiceName = ""
+			pdService, err = nil, nil
+		}
+		if err != nil {
+			SetConditionFromError(&status.Conditions, v1.ConditionServiceSynced, err, errorReason(err, "FetchFailed"), generation)
+			r.UpdateStatus(&kubeService, err)
+			return resultForError(err, r.ResyncInterval)
+		}
+		serviceExists = pdService != nil
+		if serviceExists {
+			err = checkOwner("service", pdService.ID, pdService.Description, owner)
+		}
 		if err != nil {
-			return ctrl.Result{Requeue: true}, err
+			return r.refuseNotOwned(&kubeService, err)
+		}
+	}
+	if !serviceExists && spec.Adopt != nil {
+		pdService, err = r.findServiceToAdopt(&kubeService, serviceName)
+		if err != nil {
+			logger.Error(err, "Failed to find the service to adopt")
+			SetConditionFromError(&status.Conditions, v1.ConditionServiceSynced, err, errorReason(err, "AdoptFailed"), generation)
+			r.UpdateStatus(&kubeService, err)
+			return resultForError(err, r.ResyncInterval)
 		}
 		serviceExists = pdService != nil
+		if serviceExists {
+			if err = checkOwner("service", pdService.ID, pdService.Description, owner); err != nil {
+				return r.refuseNotOwned(&kubeService, err)
+			}
+			msg := fmt.Sprintf("Adopted service %s (ID: %s)", pdService.Name, pdService.ID)
+			logger.Info(msg)
+			r.EventRecorder.Event(&kubeService, "Normal", "Adopted", msg)
+			status.Adopted = true
+		}
+	}
+	if !serviceExists && status.Creating == createService {
+		// created earlier, but its ID never made it into the status
+		pdService, err = r.findOwnedService(owner, serviceName)
+		if err != nil {
+			SetConditionFromError(&status.Conditions, v1.ConditionServiceSynced, err, errorReason(err, "FetchFailed"), generation)
+			r.UpdateStatus(&kubeService, err)
+			return resultForError(err, r.ResyncInterval)
+		}
+		serviceExists = pdService != nil
+		if serviceExists {
+			msg := fmt.Sprintf("Found service %s (ID: %s) created earlier for this resource", pdService.Name, pdService.ID)
+			logger.Info(msg)
+			r.EventRecorder.Event(&kubeService, "Normal", "Recovered", msg)
+			status.Adopted = false
+		}
+		status.Creating = ""
 	}
 	if !serviceExists {
 		pdService = &pagerduty.Service{}
+		status.Adopted = false
+	}
+
+	drift := make([]string, 0)
+	needsRename := false
+	// a missing ownership marker isn't drift worth reporting, but it's put back all the same
+	stampOwner := false
+	if serviceExists {
+		drift = serviceDrift(pdService, status.ServiceName, spec.Description, escalationPolicy.ID)
+		stampOwner = !hasOwnerMarker(pdService.Description, owner)
+		if status.BaseServiceName == "" {
+			// created before naming templates, under whatever name it has
+			status.BaseServiceName = pdService.Name
+		}
+		needsRename = status.BaseServiceName != serviceName || findStringInSlice(drift, "name") >= 0
+	}
+	renameDelay := r.renameDelay(req.NamespacedName, needsRename)
+	if renameDelay > 0 {
+		// the rename waits its turn, everything else is synced now
+		drift = removeStringFromSlice(drift, "name")
 	}
 
-	pdService.Description = spec.Description
+	pdService.Description = withOwnerMarker(spec.Description, owner)
 	pdService.EscalationPolicy = *escalationPolicy
 
-	if serviceExists {
+	if needsRename && renameDelay == 0 {
+		pdService, err = r.renamePdService(&kubeService, *pdService, serviceName)
+	} else if serviceExists && len(drift) == 0 && !stampOwner {
+		logger.V(1).Info("Service is up to date", "serviceId", pdService.ID)
+	} else if serviceExists {
 		pdService, err = r.PdClient.UpdateService(*pdService)
-	} else {
-		pdService.Name = r.generatePdServiceName(kubeService.Name, 0)
-		pdService, err = r.PdClient.CreateService(*pdService)
+	} else if err = r.startCreating(&kubeService, createService); err == nil {
+		pdService, err = r.createPdService(&kubeService, *pdService, serviceName)
+		if err == nil {
+			status.ServiceID = pdService.ID
+		}
+		r.finishCreating(&kubeService, err)
 	}
 	if err != nil {
 		logger.Error(err, "Failed to create pagerduty service resource", "service", pdService)
+		SetConditionFromError(&status.Conditions, v1.ConditionServiceSynced, err, errorReason(err, "SyncFailed"), generation)
 		r.UpdateStatus(&kubeService, fmt.Errorf("Failed to create pagerduty service"))
-		return ctrl.Result{}, err
+		return resultForError(err, r.ResyncInterval)
 	}
 	kubeService.Status.ServiceID = pdService.ID
-	kubeService.Status.ServiceName = pdService.Name
+	if renameDelay == 0 {
+		kubeService.Status.ServiceName = pdService.Name
+		kubeService.Status.BaseServiceName = serviceName
+		if r.Renames != nil {
+			r.Renames.Done(req.NamespacedName, needsRename)
+		}
+	}
+	SetCondition(&status.Conditions, v1.ConditionServiceSynced, metav1.ConditionTrue, "Synced", "", generation)
 
-	r.reconcileRoutingRules(&kubeService)
+	rulesetID, ruleErr := r.resolveRulesetID(ctx, &kubeService)
+	if ruleErr == nil && rulesetID == "" {
+		logger.Info("Waiting for the referenced PagerdutyRuleset. Will retry.", "rulesetRef", spec.RulesetRef)
+		if err = r.updateResource(ctx, &kubeService); err != nil {
+			return ctrl.Result{}, err
+		}
+		err = fmt.Errorf("Waiting for PagerdutyRuleset %s to create its ruleset", spec.RulesetRef.Name)
+		SetConditionFromError(&status.Conditions, v1.ConditionRuleSynced, err, "WaitingForRuleset", generation)
+		r.UpdateStatus(&kubeService, err)
+		return ctrl.Result{RequeueAfter: rulesetRefRequeueDelay}, nil
+	}
+	if ruleErr == nil {
+		var ruleDrift []string
+		ruleDrift, ruleErr = r.reconcileRoutingRules(&kubeService, rulesetID)
+		drift = append(drift, ruleDrift...)
+	}
+	if ruleErr != nil {
+		logger.Error(ruleErr, "Failed to reconcile the routing rule")
+	} else {
+		ruleErr = r.reconcileRuleOrder(ctx, &kubeService)
+		if ruleErr != nil {
+			logger.Error(ruleErr, "Failed to reorder the routing rules")
+		}
+	}
+	SetConditionFromError(&status.Conditions, v1.ConditionRuleSynced, ruleErr, errorReason(ruleErr, "SyncFailed"), generation)
+
+	if wasSynced && len(drift) > 0 {
+		msg := fmt.Sprintf("Corrected changes made outside the operator: %s", strings.Join(drift, ", "))
+		logger.Info(msg)
+		r.EventRecorder.Event(&kubeService, "Warning", "DriftCorrected", msg)
+		now := metav1.Now()
+		status.LastDriftDetected = &now
+	}
+
+	err = r.updateResource(ctx, &kubeService)
+	if err == nil {
+		err = ruleErr
+	}
+	// persist the service ID even if the rule failed, so we don't create a duplicate service next time
+	if statusErr := r.UpdateStatus(&kubeService, err); statusErr != nil && err == nil {
+		err = statusErr
+	}
+	result, err := resultForError(err, r.ResyncInterval)
+	if err == nil && renameDelay > 0 && (result.RequeueAfter == 0 || renameDelay < result.RequeueAfter) {
+		result.RequeueAfter = renameDelay
+	}
+	return result, err
+}
 
-	err = r.Update(ctx, kubeService.DeepCopyObject())
-	r.UpdateStatus(&kubeService, err)
-	return ctrl.Result{}, err
+// updateResource persists the resource's metadata and spec. Update replaces the resource
+// with what the API server returns, so the status built up in memory is restored afterwards.
+func (r *PagerdutyServiceReconciler) updateResource(ctx context.Context, kubeService *v1.PagerdutyService) error {
+	status := kubeService.Status.DeepCopy()
+	err := r.Update(ctx, kubeService)
+	kubeService.Status = *status
+	return err
 }
 
-func (r *PagerdutyServiceReconciler) reconcileRoutingRules(kubeService *v1.PagerdutyService) error {
-	ruleset, _, err := r.PdClient.GetRuleset(r.RulesetID)
-	if err != nil {
+// startCreating records that a service or rule is about to be created, so if its ID is lost
+// the next reconcile knows to look for it. Nothing is created unless that's saved.
+func (r *PagerdutyServiceReconciler) startCreating(kubeService *v1.PagerdutyService, kind string) error {
+	kubeService.Status.Creating = kind
+	return r.saveStatus(kubeService)
+}
+
+// finishCreating saves the ID of what was just created, so a failure later on doesn't lead to a duplicate.
+// Failing to save is only logged, since the next reconcile looks for what was created. When pagerduty
+// refused the request nothing was created, but after a server or network error it may have been.
+func (r *PagerdutyServiceReconciler) finishCreating(kubeService *v1.PagerdutyService, err error) {
+	if code := pdhelpers.StatusCode(err); err == nil || (code >= 400 && code < 500) {
+		kubeService.Status.Creating = ""
+	}
+	if err == nil {
+		if err = r.saveStatus(kubeService); err != nil {
+			logger.Error(err, "Failed to save the IDs of the created pagerduty objects")
+		}
+	}
+}
+
+// saveStatus persists the status right away. The API server's copy would drop the finalizer
+// added in memory, so only the new resource version is kept from it.
+func (r *PagerdutyServiceReconciler) saveStatus(kubeService *v1.PagerdutyService) error {
+	saved := kubeService.DeepCopy()
+	if err := r.Status().Update(context.Background(), saved); err != nil {
 		return err
 	}
+	kubeService.ResourceVersion = saved.ResourceVersion
+	return nil
+}
 
-	var rule *pagerduty.RulesetRule
-	ruleID := kubeService.Status.RuleID
-	ruleExists := ruleID != ""
+// resolveRulesetID finds the ruleset the service's rules belong in. An empty ID without
+// an error means the referenced PagerdutyRuleset doesn't have a ruleset yet.
+func (r *PagerdutyServiceReconciler) resolveRulesetID(ctx context.Context, kubeService *v1.PagerdutyService) (string, error) {
+	ref := kubeService.Spec.RulesetRef
+	if ref == nil {
+		if r.RulesetID == "" {
+			return "", fmt.Errorf("No rulesetRef given and the operator has no default ruleset")
+		}
+		return r.RulesetID, nil
+	}
 
-	if !ruleExists {
-		logger.Info("Creating new rule")
-		rule = &pagerduty.RulesetRule{
-			Ruleset: &pagerduty.APIObject{
-				ID: ruleset.ID,
-			},
+	name := types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}
+	if name.Namespace == "" {
+		name.Namespace = kubeService.Namespace
+	}
+	var kubeRuleset v1.PagerdutyRuleset
+	if err := r.Get(ctx, name, &kubeRuleset); err != nil {
+		return "", fmt.Errorf("Unable to fetch PagerdutyRuleset %s: %v", name, err)
+	}
+	return kubeRuleset.Status.RulesetID, nil
+}
+
+// managedRulesetID is the ruleset the service's rules were written to.
+// Older versions of the operator didn't record it, and only knew the default ruleset.
+func (r *PagerdutyServiceReconciler) managedRulesetID(status *v1.PagerdutyServiceStatus) string {
+	if status.RulesetID == "" {
+		return r.RulesetID
+	}
+	return status.RulesetID
+}
+
+// reconcileRoutingRules creates, updates and deletes the service's rules to match its spec.
+// It returns the parts of existing rules that had to be corrected.
+func (r *PagerdutyServiceReconciler) reconcileRoutingRules(kubeService *v1.PagerdutyService, rulesetID string) ([]string, error) {
+	ruleset, _, err := r.PdClient.GetRuleset(rulesetID)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := r.ownerOf(kubeService)
+
+	// the service moved to another ruleset, so start over there
+	if previousRulesetID := r.managedRulesetID(&kubeService.Status); previousRulesetID != rulesetID {
+		if err = r.deleteRoutingRules(previousRulesetID, managedRuleIDs(&kubeService.Status), owner); err != nil {
+			return nil, err
 		}
-	} else {
-		logger.V(1).Info("Using existing rule")
-		rule, _, err = r.PdClient.GetRulesetRule(ruleset.ID, ruleID)
+		setManagedRuleIDs(&kubeService.Status, nil)
+	}
+	kubeService.Status.RulesetID = rulesetID
+
+	allConditions, err := buildRuleConditions(&kubeService.Spec, r.DefaultSourceProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	var priorityID string
+	if actionsSpec := kubeService.Spec.Actions; actionsSpec != nil && actionsSpec.Priority != "" {
+		priorityHelper := pdhelpers.PriorityHelper{PriorityClient: r.PdClient}
+		priorityID, err = priorityHelper.GetPriorityIDByName(actionsSpec.Priority)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
+	actions, err := buildRuleActions(kubeService.Spec.Actions, kubeService.Status.ServiceID, priorityID)
+	if err != nil {
+		return nil, err
+	}
+	timeFrame, timeFrameDescription, err := buildTimeFrame(kubeService.Spec.TimeFrame)
+	if err != nil {
+		return nil, err
+	}
+
+	existingRuleIDs := managedRuleIDs(&kubeService.Status)
+	ruleIDs := make([]string, 0, len(allConditions))
+	drift := make([]string, 0)
+	// a rule was created earlier, but its ID never made it into the status
+	recovering := kubeService.Status.Creating == createRule
+	// the rules it could be, listed the first time a rule is missing
+	var lost []*pagerduty.RulesetRule
+	for idx, conditions := range allConditions {
+		conditions = withRuleOwner(conditions, owner)
+		var rule *pagerduty.RulesetRule
+		ruleExists := idx < len(existingRuleIDs)
+
+		if !ruleExists {
+			logger.Info("Creating new rule")
+			rule = &pagerduty.RulesetRule{
+				Ruleset: &pagerduty.APIObject{
+					ID: ruleset.ID,
+				},
+			}
+		} else {
+			logger.V(1).Info("Using existing rule")
+			rule, _, err = r.PdClient.GetRulesetRule(ruleset.ID, existingRuleIDs[idx])
+			if pdhelpers.IsNotFound(err) {
+				r.recordRecreation(kubeService, "rule", existingRuleIDs[idx])
+				ruleExists = false
+				rule = &pagerduty.RulesetRule{
+					Ruleset: &pagerduty.APIObject{
+						ID: ruleset.ID,
+					},
+				}
+			} else if err != nil {
+				return nil, err
+			} else if err = checkOwner("rule", rule.ID, ruleOwnerMarker(rule), owner); err != nil {
+				return nil, err
+			}
+		}
+		if !ruleExists && recovering {
+			if lost == nil {
+				if lost, err = r.findLostRules(ruleset.ID, kubeService.Status.ServiceID, existingRuleIDs); err != nil {
+					return nil, err
+				}
+			}
+			if found := claimLostRule(&lost, conditions); found != nil {
+				rule = found
+				ruleExists = true
+				logger.Info("Found a routing rule created earlier for this resource", "ruleID", rule.ID)
+			}
+		}
+
+		desired := &pagerduty.RulesetRule{Conditions: conditions, Actions: actions, TimeFrame: timeFrame}
+		if ruleExists {
+			// a missing ownership marker isn't drift worth reporting, but it's put back all the same
+			changed := ruleDrift(rule, desired)
+			if len(changed) == 0 && hasOwnerMarker(ruleOwnerMarker(rule), owner) {
+				ruleIDs = append(ruleIDs, rule.ID)
+				continue
+			}
+			for _, field := range changed {
+				drift = append(drift, fmt.Sprintf("rule %s %s", rule.ID, field))
+			}
+		}
 
-	conditions := pagerduty.RuleConditions{
-		Operator: "and",
+		rule.Conditions = conditions
+		rule.Actions = actions
+		rule.TimeFrame = timeFrame
+
+		if ruleExists {
+			rule, _, err = r.PdClient.UpdateRulesetRule(ruleset.ID, rule.ID, rule)
+			logger.Info("Updated routing rule", "rule", rule)
+		} else if err = r.startCreating(kubeService, createRule); err == nil {
+			rule, _, err = r.PdClient.CreateRulesetRule(ruleset.ID, rule)
+			logger.Info("Created routing rule", "rule", rule)
+			if err == nil {
+				// along with the rules that are still to be checked
+				saved := append(append([]string{}, ruleIDs...), rule.ID)
+				if idx+1 < len(existingRuleIDs) {
+					saved = append(saved, existingRuleIDs[idx+1:]...)
+				}
+				setManagedRuleIDs(&kubeService.Status, saved)
+			}
+			r.finishCreating(kubeService, err)
+		}
+
+		if err != nil {
+			// keep track of what we've got so far, so the next attempt doesn't create duplicates
+			setManagedRuleIDs(&kubeService.Status, append(ruleIDs, existingRuleIDs[idx:]...))
+			return nil, err
+		}
+		ruleIDs = append(ruleIDs, rule.ID)
 	}
-	for _, labelSpec := range kubeService.Spec.MatchLabels {
-		subcondition := pagerduty.RuleSubcondition{
-			Operator: "contains",
-			Parameters: &pagerduty.ConditionParameter{
-				Path:  "details.firing",
-				Value: fmt.Sprintf("%s = %s", labelSpec.Key, labelSpec.Value),
-			},
+
+	// the selectors shrank, so remove the rules we no longer need
+	for idx := len(ruleIDs); idx < len(existingRuleIDs); idx++ {
+		if err = r.PdClient.DeleteRulesetRule(ruleset.ID, existingRuleIDs[idx]); err != nil {
+			setManagedRuleIDs(&kubeService.Status, append(ruleIDs, existingRuleIDs[idx:]...))
+			return nil, err
 		}
-		conditions.RuleSubconditions = append(conditions.RuleSubconditions, &subcondition)
+		logger.Info("Deleted routing rule", "ruleID", existingRuleIDs[idx])
 	}
-	rule.Conditions = &conditions
 
-	serviceID := kubeService.Status.ServiceID
-	rule.Actions = &pagerduty.RuleActions{
-		Route: &pagerduty.RuleActionParameter{Value: serviceID},
+	setManagedRuleIDs(&kubeService.Status, ruleIDs)
+	kubeService.Status.TimeFrame = timeFrameDescription
+	if recovering {
+		kubeService.Status.Creating = ""
 	}
 
-	if ruleExists {
-		rule, _, err = r.PdClient.UpdateRulesetRule(ruleset.ID, rule.ID, rule)
-		logger.Info("Updated routing rule", "rule", rule)
-	} else {
-		rule, _, err = r.PdClient.CreateRulesetRule(ruleset.ID, rule)
-		logger.Info("Created routing rule", "rule", rule)
+	return drift, nil
+}
+
+// recordRecreation notes that an object we manage was deleted in pagerduty behind our back
+func (r *PagerdutyServiceReconciler) recordRecreation(kubeService *v1.PagerdutyService, kind string, id string) {
+	msg := fmt.Sprintf("The %s %s was deleted in pagerduty, recreating it", kind, id)
+	logger.Info(msg)
+	r.EventRecorder.Event(kubeService, "Warning", "Recreated", msg)
+	kubeService.Status.Recreations++
+}
+
+// reconcileRuleOrder moves the rules of all PagerdutyServices around the ruleset, according to their priorities
+func (r *PagerdutyServiceReconciler) reconcileRuleOrder(ctx context.Context, kubeService *v1.PagerdutyService) error {
+	var kubeServices v1.PagerdutyServiceList
+	if err := r.List(ctx, &kubeServices); err != nil {
+		return err
+	}
+
+	priorities := make(map[string]int)
+	for _, other := range kubeServices.Items {
+		for _, ruleID := range managedRuleIDs(&other.Status) {
+			priorities[ruleID] = other.Spec.RulePriority
+		}
+	}
+	// the rules of the service being reconciled may not have been persisted yet
+	for _, ruleID := range managedRuleIDs(&kubeService.Status) {
+		priorities[ruleID] = kubeService.Spec.RulePriority
 	}
 
+	rulesetID := kubeService.Status.RulesetID
+	rules, err := r.PdClient.ListRulesetRules(rulesetID)
 	if err != nil {
 		return err
 	}
 
-	kubeService.Status.RuleID = rule.ID
-
+	rulesByID := make(map[string]*pagerduty.RulesetRule)
+	for _, rule := range rules.Rules {
+		rulesByID[rule.ID] = rule
+	}
+	for _, move := range pdhelpers.PlanRuleMoves(rules.Rules, priorities) {
+		position := move.Position
+		rule := *rulesByID[move.RuleID]
+		rule.Position = &position
+		_, _, err = r.PdClient.UpdateRulesetRule(rulesetID, move.RuleID, &rule)
+		if err != nil {
+			return err
+		}
+		logger.Info("Moved routing rule", "ruleID", move.RuleID, "position", position)
+	}
 	return nil
 }
 
+// managedRuleIDs lists the rules belonging to the service, including
+// the single RuleID recorded by older versions of the operator
+func managedRuleIDs(status *v1.PagerdutyServiceStatus) []string {
+	if len(status.RuleIDs) == 0 && status.RuleID != "" {
+		return []string{status.RuleID}
+	}
+	return status.RuleIDs
+}
+
+func setManagedRuleIDs(status *v1.PagerdutyServiceStatus, ruleIDs []string) {
+	status.RuleIDs = ruleIDs
+	status.RuleID = ""
+	if len(ruleIDs) > 0 {
+		status.RuleID = ruleIDs[0]
+	}
+}
+
 func (r *PagerdutyServiceReconciler) destroyPagerdutyResources(kubeService *v1.PagerdutyService) error {
 	logger.Info("Resource is marked for deletion. Cleaning up.")
 	var err error
 
-	ruleID := kubeService.Status.RuleID
-	if ruleID != "" {
-		err := r.PdClient.DeleteRulesetRule(r.RulesetID, ruleID)
-		if err != nil {
-			if strings.Contains(err.Error(), "404") {
-				logger.Info(fmt.Sprintf("Unable to delete rule %s but it does not exist.", ruleID))
-			} else {
-				return err
-			}
+	owner := r.ownerOf(kubeService)
+	serviceID := kubeService.Status.ServiceID
+	if serviceID != "" {
+		pdService, err := r.PdClient.GetService(serviceID, &pagerduty.GetServiceOptions{})
+		if err == nil {
+			err = checkOwner("service", serviceID, pdService.Description, owner)
+		}
+		if notOwned, ok := err.(*ownershipError); ok {
+			// another cluster took it over, so it's theirs to clean up
+			logger.Info("Leaving the pagerduty service to its owner", "error", notOwned.Error())
+			r.EventRecorder.Event(kubeService, "Warning", "NotOwned", notOwned.Error())
+			serviceID = ""
+		} else if err != nil && !pdhelpers.IsNotFound(err) {
+			return err
+		}
+	}
+	policy := r.deletionPolicy(kubeService)
+	if serviceID != "" && policy == v1.DeletionPolicyDelete {
+		// before anything is deleted, so a blocked deletion leaves the routing intact
+		if err = r.handleOpenIncidents(kubeService); err != nil {
+			return err
 		}
-		logger.Info("Successfully deleted the routing rule")
 	}
 
-	serviceID := kubeService.Status.ServiceID
-	if serviceID != "" {
-		err = r.PdClient.DeleteService(kubeService.Status.ServiceID)
-		if err != nil {
-			if strings.Contains(err.Error(), "404") {
-				logger.Info(fmt.Sprintf("Tried to delete service %s but it does not exist.", serviceID))
-			} else {
-				return err
-			}
+	err = r.deleteRoutingRules(r.managedRulesetID(&kubeService.Status), managedRuleIDs(&kubeService.Status), owner)
+	if err != nil {
+		return err
+	}
+
+	if serviceID == "" {
+		return nil
+	}
+	switch policy {
+	case v1.DeletionPolicyRetain:
+		err = r.releasePdService(serviceID, owner)
+		if pdhelpers.IsNotFound(err) {
+			logger.Info(fmt.Sprintf("Tried to retain service %s but it does not exist.", serviceID))
+			return nil
+		} else if err != nil {
 			return err
 		}
-		logger.Info("Successfully deleted the pagerduty service")
+		logger.Info("Leaving the pagerduty service in place", "serviceId", serviceID, "deletionPolicy", policy)
+		r.EventRecorder.Event(kubeService, "Normal", "Retained", fmt.Sprintf("Retained service %s", serviceID))
+	case v1.DeletionPolicyDisable:
+		err = r.disablePdService(kubeService)
+		if pdhelpers.IsNotFound(err) {
+			logger.Info(fmt.Sprintf("Tried to disable service %s but it does not exist.", serviceID))
+		} else if err != nil {
+			return err
+		}
+	default:
+		err = r.PdClient.DeleteService(serviceID)
+		if pdhelpers.IsNotFound(err) {
+			logger.Info(fmt.Sprintf("Tried to delete service %s but it does not exist.", serviceID))
+		} else if err != nil {
+			return err
+		} else {
+			logger.Info("Successfully deleted the pagerduty service")
+		}
 	}
 
 	return nil
 }
 
+// refuseNotOwned leaves a service another resource owns alone, checking again at the next resync
+func (r *PagerdutyServiceReconciler) refuseNotOwned(kubeService *v1.PagerdutyService, err error) (ctrl.Result, error) {
+	logger.Info("Refusing to manage the service", "error", err.Error())
+	r.EventRecorder.Event(kubeService, "Warning", "NotOwned", err.Error())
+	SetConditionFromError(&kubeService.Status.Conditions, v1.ConditionServiceSynced, err, "NotOwned", kubeService.Generation)
+	r.UpdateStatus(kubeService, err)
+	return resultForError(err, r.ResyncInterval)
+}
+
+// findServiceToAdopt looks up the existing service the resource adopts, by ID or by name.
+// A nil service means there was nothing to adopt by name.
+func (r *PagerdutyServiceReconciler) findServiceToAdopt(kubeService *v1.PagerdutyService, serviceName string) (*pagerduty.Service, error) {
+	adopt := kubeService.Spec.Adopt
+	if adopt.ServiceID != "" {
+		return r.PdClient.GetService(adopt.ServiceID, &pagerduty.GetServiceOptions{})
+	}
+	if adopt.ByName {
+		helper := pdhelpers.ServiceHelper{ServiceClient: r.PdClient}
+		return helper.FindServiceByName(serviceName)
+	}
+	return nil, nil
+}
+
+// deleteRoutingRules deletes the rules, except the ones another resource owns
+func (r *PagerdutyServiceReconciler) deleteRoutingRules(rulesetID string, ruleIDs []string, owner Owner) error {
+	for _, ruleID := range ruleIDs {
+		rule, _, err := r.PdClient.GetRulesetRule(rulesetID, ruleID)
+		if err == nil {
+			err = checkOwner("rule", ruleID, ruleOwnerMarker(rule), owner)
+			if err == nil {
+				err = r.PdClient.DeleteRulesetRule(rulesetID, ruleID)
+			}
+		}
+		if _, notOwned := err.(*ownershipError); notOwned {
+			logger.Info("Leaving the routing rule to its owner", "error", err.Error())
+		} else if pdhelpers.IsNotFound(err) {
+			logger.Info(fmt.Sprintf("Unable to delete rule %s but it does not exist.", ruleID))
+		} else if err != nil {
+			return err
+		} else {
+			logger.Info("Successfully deleted the routing rule", "ruleID", ruleID, "rulesetID", rulesetID)
+		}
+	}
+	return nil
+}
+
 // UpdateStatus sets the value of the service's Status.Status field to SUCCESS or ERROR
-// based on the value of the supplied error. It persists this to etcd immediately.
-func (r *PagerdutyServiceReconciler) UpdateStatus(service *v1.PagerdutyService, err error) {
+// based on the value of the supplied error, and works out the Ready condition.
+// It persists the status to etcd immediately.
+func (r *PagerdutyServiceReconciler) UpdateStatus(service *v1.PagerdutyService, err error) error {
 	var status string
 	if err == nil {
 		status = "SUCCESS"
@@ -241,19 +771,22 @@ func (r *PagerdutyServiceReconciler) UpdateStatus(service *v1.PagerdutyService,
 		status = fmt.Sprintf("ERROR: %s", err.Error())
 	}
 	service.Status.Status = status
-	r.Status().Update(context.Background(), service)
-}
+	service.Status.ObservedGeneration = service.Generation
 
-// generatePdServiceName prepends the configured prefix if applicable
-// it will also add a random suffix of a given length (to overcome pagerduty's flat namespace for service names)
-func (r *PagerdutyServiceReconciler) generatePdServiceName(name string, randomSuffixLen int) string {
-	if r.ServicePrefix != "" {
-		name = r.ServicePrefix + "-" + name
+	conditions := &service.Status.Conditions
+	if service.DeletionTimestamp.IsZero() {
+		SetCondition(conditions, v1.ConditionDeleting, metav1.ConditionFalse, "NotDeleting", "", service.Generation)
+		SetReadyCondition(conditions, service.Generation,
+			v1.ConditionEscalationPolicyResolved, v1.ConditionServiceSynced, v1.ConditionRuleSynced)
+	} else {
+		SetCondition(conditions, v1.ConditionReady, metav1.ConditionFalse, "Deleting", "", service.Generation)
 	}
-	if randomSuffixLen > 0 {
-		name = name + "-" + uniuri.NewLen(randomSuffixLen)
+
+	updateErr := r.Status().Update(context.Background(), service)
+	if updateErr != nil {
+		logger.Error(updateErr, "Failed to update the PagerdutyService status")
 	}
-	return name
+	return updateErr
 }
 
 // GetEscalationPolicyID returns an escalation policy ID for the given service,
@@ -312,11 +845,17 @@ func (r *PagerdutyServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 // This can be replaces with pdhelpers.ServiceClient once refactors are complete
 type ServiceReconcilerPagerdutyInterface interface {
 	GetEscalationPolicy(id string, opt *pagerduty.GetEscalationPolicyOptions) (*pagerduty.EscalationPolicy, error)
+	ListPriorities() (*pagerduty.Priorities, error)
 	GetService(id string, opts *pagerduty.GetServiceOptions) (*pagerduty.Service, error)
 	UpdateService(service pagerduty.Service) (*pagerduty.Service, error)
 	CreateService(service pagerduty.Service) (*pagerduty.Service, error)
+	ListServices(o pagerduty.ListServiceOptions) (*pagerduty.ListServiceResponse, error)
+	ListIncidents(o pagerduty.ListIncidentsOptions) (*pagerduty.ListIncidentsResponse, error)
+	ManageIncidents(from string, incidents []pagerduty.ManageIncidentsOptions) (*pagerduty.ListIncidentsResponse, error)
+	CreateIncidentNoteWithResponse(id string, note pagerduty.IncidentNote) (*pagerduty.IncidentNote, error)
 	GetRuleset(id string) (*pagerduty.Ruleset, *http.Response, error)
 	GetRulesetRule(ruleID string, rulesetID string) (*pagerduty.RulesetRule, *http.Response, error)
+	ListRulesetRules(rulesetID string) (*pagerduty.ListRulesetRulesResponse, error)
 	UpdateRulesetRule(ruleID string, rulesetID string, rule *pagerduty.RulesetRule) (*pagerduty.RulesetRule, *http.Response, error)
 	CreateRulesetRule(ruleID string, rule *pagerduty.RulesetRule) (*pagerduty.RulesetRule, *http.Response, error)
 	DeleteRulesetRule(ruleID string, rulesetID string) error
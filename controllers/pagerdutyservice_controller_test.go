@@ -132,7 +132,7 @@ var _ = Describe("PagerdutyService controller", func() {
 			}, timeout, interval).Should(BeTrue())
 
 			Eventually(func() string {
-				return pdClientMock.service.Description
+				return withoutOwnerMarker(pdClientMock.service.Description)
 			}, timeout, interval).Should(Equal(updatedPdService.Spec.Description))
 
 			Eventually(func() string {
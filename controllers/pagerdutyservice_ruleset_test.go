@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+// TestResolveRulesetID checks where a PagerdutyService's rules end up
+func TestResolveRulesetID(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	ready := &v1.PagerdutyRuleset{
+		ObjectMeta: metav1.ObjectMeta{Name: "ready", Namespace: "monitoring"},
+		Status:     v1.PagerdutyRulesetStatus{RulesetID: "RS1"},
+	}
+	pending := &v1.PagerdutyRuleset{
+		ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default"},
+	}
+	r := newTestReconciler(ready, pending)
+	r.RulesetID = "DEFAULT"
+
+	service := &v1.PagerdutyService{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"}}
+	id, err := r.resolveRulesetID(ctx, service)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(id).To(Equal("DEFAULT"))
+
+	// the namespace defaults to the service's own
+	service.Spec.RulesetRef = &v1.PagerdutyRulesetRef{Name: "ready"}
+	_, err = r.resolveRulesetID(ctx, service)
+	g.Expect(err).To(HaveOccurred())
+
+	service.Spec.RulesetRef.Namespace = "monitoring"
+	id, err = r.resolveRulesetID(ctx, service)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(id).To(Equal("RS1"))
+
+	// a ruleset without an ID yet means waiting
+	service.Spec.RulesetRef = &v1.PagerdutyRulesetRef{Name: "pending"}
+	id, err = r.resolveRulesetID(ctx, service)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(id).To(BeEmpty())
+
+	// and without a ref, there has to be a default
+	r = newTestReconciler()
+	r.RulesetID = ""
+	service.Spec.RulesetRef = nil
+	_, err = r.resolveRulesetID(ctx, service)
+	g.Expect(err).To(HaveOccurred())
+}
+
+// TestRulesetChange ensures rules follow the service to its new ruleset
+func TestRulesetChange(t *testing.T) {
+	g := NewGomegaWithT(t)
+	service := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"},
+		Spec: v1.PagerdutyServiceSpec{
+			SelectorSpec: v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+		},
+		Status: v1.PagerdutyServiceStatus{ServiceID: "SVC", RuleID: "OLDRULE"},
+	}
+	r := newTestReconciler(service.DeepCopy())
+	r.RulesetID = "DEFAULT"
+	g.Expect(r.managedRulesetID(&service.Status)).To(Equal("DEFAULT"))
+
+	_, err := r.reconcileRoutingRules(service, "RS1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(service.Status.RulesetID).To(Equal("RS1"))
+	g.Expect(service.Status.RuleIDs).To(Equal([]string{testID}))
+}
@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	pd "github.com/PagerDuty/go-pagerduty"
 )
@@ -20,42 +22,132 @@ type PagerdutyClientMock struct {
 	rulesetRule *pd.RulesetRule
 
 	updateServiceCalled bool
+
+	// deletedIDs are services and rules that were deleted behind the operator's back
+	deletedIDs map[string]bool
+	// takenNames are service names already used in the pagerduty account
+	takenNames map[string]bool
+	// openIncidents are the service's triggered and acknowledged incidents
+	openIncidents []pd.Incident
+	// incidentNotes are the notes added to incidents, by incident ID
+	incidentNotes map[string][]pd.IncidentNote
+}
+
+// notFoundError is what the pagerduty client returns for a missing object
+func notFoundError() error {
+	return fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: &{2100 Not Found []}")
+}
+
+// nameTakenError is what the pagerduty client returns when a service name is in use
+func nameTakenError() error {
+	return fmt.Errorf("Failed call API endpoint. HTTP response code: 400. Error: &{2001 Invalid Input Provided [Name has already been taken.]}")
 }
 
 func (pdc *PagerdutyClientMock) Reset() {
 	pdc.service = nil
 	pdc.rulesetRule = nil
 	pdc.updateServiceCalled = false
+	pdc.deletedIDs = nil
+	pdc.takenNames = nil
+	pdc.openIncidents = nil
+	pdc.incidentNotes = nil
 }
 
 func (pdc *PagerdutyClientMock) GetEscalationPolicy(id string, opt *pd.GetEscalationPolicyOptions) (*pd.EscalationPolicy, error) {
 	return &pd.EscalationPolicy{APIObject: pd.APIObject{ID: id}}, nil
 }
 
+func (pdc *PagerdutyClientMock) ListPriorities() (*pd.Priorities, error) {
+	return &pd.Priorities{
+		Priorities: []pd.PriorityProperty{
+			{APIObject: pd.APIObject{ID: "PRIO1"}, Name: "P1"},
+			{APIObject: pd.APIObject{ID: "PRIO2"}, Name: "P2"},
+		},
+	}, nil
+}
+
 func (pdc *PagerdutyClientMock) GetService(id string, opts *pd.GetServiceOptions) (*pd.Service, error) {
+	if pdc.deletedIDs[id] || pdc.service == nil {
+		return nil, notFoundError()
+	}
 	return pdc.service, nil
 }
 
 func (pdc *PagerdutyClientMock) UpdateService(service pd.Service) (*pd.Service, error) {
+	if pdc.takenNames[service.Name] {
+		return nil, nameTakenError()
+	}
 	pdc.service = &service
 	pdc.updateServiceCalled = true
 	return pdc.service, nil
 }
 
 func (pdc *PagerdutyClientMock) CreateService(service pd.Service) (*pd.Service, error) {
+	if pdc.takenNames[service.Name] {
+		return nil, nameTakenError()
+	}
 	service.ID = testID
 	pdc.service = &service
 	return &service, nil
 }
 
+func (pdc *PagerdutyClientMock) ListServices(o pd.ListServiceOptions) (*pd.ListServiceResponse, error) {
+	resp := &pd.ListServiceResponse{}
+	if pdc.service != nil && strings.Contains(pdc.service.Name, o.Query) {
+		resp.Services = append(resp.Services, *pdc.service)
+	}
+	return resp, nil
+}
+
+func (pdc *PagerdutyClientMock) ListIncidents(o pd.ListIncidentsOptions) (*pd.ListIncidentsResponse, error) {
+	return &pd.ListIncidentsResponse{Incidents: pdc.openIncidents}, nil
+}
+
+func (pdc *PagerdutyClientMock) ManageIncidents(from string, incidents []pd.ManageIncidentsOptions) (*pd.ListIncidentsResponse, error) {
+	resolved := make(map[string]bool)
+	for _, incident := range incidents {
+		resolved[incident.ID] = incident.Status == "resolved"
+	}
+	open := make([]pd.Incident, 0)
+	for _, incident := range pdc.openIncidents {
+		if !resolved[incident.ID] {
+			open = append(open, incident)
+		}
+	}
+	pdc.openIncidents = open
+	return &pd.ListIncidentsResponse{}, nil
+}
+
+func (pdc *PagerdutyClientMock) CreateIncidentNoteWithResponse(id string, note pd.IncidentNote) (*pd.IncidentNote, error) {
+	if pdc.incidentNotes == nil {
+		pdc.incidentNotes = make(map[string][]pd.IncidentNote)
+	}
+	pdc.incidentNotes[id] = append(pdc.incidentNotes[id], note)
+	return &note, nil
+}
+
 func (pdc *PagerdutyClientMock) GetRuleset(id string) (*pd.Ruleset, *http.Response, error) {
 	return &pd.Ruleset{ID: id}, okResponse, nil
 }
 
 func (pdc *PagerdutyClientMock) GetRulesetRule(rulesetID string, ruleID string) (*pd.RulesetRule, *http.Response, error) {
+	if pdc.deletedIDs[ruleID] {
+		return nil, &http.Response{StatusCode: http.StatusNotFound}, notFoundError()
+	}
+	if pdc.rulesetRule != nil && pdc.rulesetRule.ID == ruleID {
+		return pdc.rulesetRule, okResponse, nil
+	}
 	return &pd.RulesetRule{ID: ruleID}, okResponse, nil
 }
 
+func (pdc *PagerdutyClientMock) ListRulesetRules(rulesetID string) (*pd.ListRulesetRulesResponse, error) {
+	rules := make([]*pd.RulesetRule, 0, 1)
+	if pdc.rulesetRule != nil {
+		rules = append(rules, pdc.rulesetRule)
+	}
+	return &pd.ListRulesetRulesResponse{Rules: rules}, nil
+}
+
 func (pdc *PagerdutyClientMock) UpdateRulesetRule(rulesetID string, ruleID string, rule *pd.RulesetRule) (*pd.RulesetRule, *http.Response, error) {
 	pdc.rulesetRule = rule
 	return rule, okResponse, nil
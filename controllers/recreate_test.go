@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+// TestRecreateDeletedObjects ensures services and rules deleted in pagerduty are recreated
+func TestRecreateDeletedObjects(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"},
+		Spec: v1.PagerdutyServiceSpec{
+			EscalationPolicy: "EP1",
+			SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+		},
+		Status: v1.PagerdutyServiceStatus{ServiceID: "GONESVC", RuleIDs: []string{"GONERULE"}, RulesetID: "RS1"},
+	}
+	pdClient := &PagerdutyClientMock{deletedIDs: map[string]bool{"GONESVC": true, "GONERULE": true}}
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+
+	name := types.NamespacedName{Name: "svc", Namespace: "default"}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var reconciled v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	g.Expect(reconciled.Status.ServiceID).To(Equal(testID))
+	g.Expect(reconciled.Status.RuleIDs).To(Equal([]string{testID}))
+	g.Expect(reconciled.Status.Recreations).To(Equal(2))
+	g.Expect(isConditionTrue(reconciled.Status.Conditions, v1.ConditionReady)).To(BeTrue())
+
+	g.Expect(<-recorder.Events).To(ContainSubstring("service GONESVC was deleted"))
+	g.Expect(<-recorder.Events).To(ContainSubstring("rule GONERULE was deleted"))
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	serviceRenamesPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pagerduty_service_renames_pending",
+		Help: "Services waiting to be renamed by the rename migration",
+	})
+	serviceRenames = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pagerduty_service_renames_total",
+		Help: "Services renamed by the rename migration",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(serviceRenamesPending, serviceRenames)
+}
+
+// RenameMigration spreads out service renames, so changing the name template or prefix
+// doesn't rename every service at once
+type RenameMigration struct {
+	limiter *rate.Limiter
+	now     func() time.Time
+
+	mutex   sync.Mutex
+	pending map[types.NamespacedName]time.Time
+	renamed int
+}
+
+// NewRenameMigration allows perMinute renames a minute
+func NewRenameMigration(perMinute float64) *RenameMigration {
+	return &RenameMigration{
+		limiter: rate.NewLimiter(rate.Limit(perMinute/60), 1),
+		now:     time.Now,
+		pending: make(map[types.NamespacedName]time.Time),
+	}
+}
+
+// Wait returns how long until the service may be renamed, holding its place in line
+func (m *RenameMigration) Wait(name types.NamespacedName) time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	if at, ok := m.pending[name]; ok {
+		if now.Before(at) {
+			return at.Sub(now)
+		}
+		return 0
+	}
+	delay := m.limiter.ReserveN(now, 1).DelayFrom(now)
+	if delay > 0 {
+		m.pending[name] = now.Add(delay)
+		serviceRenamesPending.Set(float64(len(m.pending)))
+		logger.Info("Service rename queued", "pagerdutyservice", name, "delay", delay, "pending", len(m.pending))
+	}
+	return delay
+}
+
+// Done records that the service was renamed, or no longer needs to be
+func (m *RenameMigration) Done(name types.NamespacedName, renamed bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, wasPending := m.pending[name]
+	delete(m.pending, name)
+	serviceRenamesPending.Set(float64(len(m.pending)))
+	if renamed {
+		m.renamed++
+		serviceRenames.Inc()
+	}
+	if renamed || wasPending {
+		logger.Info("Service rename progress", "renamed", m.renamed, "pending", len(m.pending))
+	}
+}
+
+// Pending returns how many services are waiting to be renamed
+func (m *RenameMigration) Pending() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.pending)
+}
+
+// renameDelay returns how long a service that needs a rename has to wait for it
+func (r *PagerdutyServiceReconciler) renameDelay(name types.NamespacedName, needsRename bool) time.Duration {
+	if !needsRename || r.Renames == nil {
+		return 0
+	}
+	return r.Renames.Wait(name)
+}
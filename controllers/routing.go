@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+// Alertmanager renders the labels of firing alerts into details.firing,
+// one " - key = value" line per label
+const firingPath = "details.firing"
+
+// keyPlaceholder is replaced by the label key in field paths
+const keyPlaceholder = "{key}"
+
+// Operators understood by pagerduty ruleset rule subconditions
+const (
+	pdOpEquals      = "equals"
+	pdOpNotEquals   = "nequals"
+	pdOpContains    = "contains"
+	pdOpNotContains = "ncontains"
+	pdOpMatches     = "matches"
+	pdOpNotMatches  = "nmatches"
+	pdOpExists      = "exists"
+	pdOpNotExists   = "nexists"
+)
+
+// sourceProfilePaths maps each source profile to the event field holding a label
+var sourceProfilePaths = map[v1.SourceProfile]string{
+	v1.SourceProfileAlertmanager:  firingPath,
+	v1.SourceProfileCustomDetails: "details." + keyPlaceholder,
+	v1.SourceProfileGrafana:       "details.labels." + keyPlaceholder,
+}
+
+// IsKnownSourceProfile reports whether labels can be routed for the given source profile
+func IsKnownSourceProfile(profile v1.SourceProfile) bool {
+	_, ok := sourceProfilePaths[profile]
+	return ok
+}
+
+// fieldResolver works out which event field a label is matched against
+type fieldResolver struct {
+	defaultPath string
+}
+
+func newFieldResolver(spec *v1.PagerdutyServiceSpec, defaultProfile v1.SourceProfile) (*fieldResolver, error) {
+	if spec.FieldPath != "" {
+		return &fieldResolver{defaultPath: spec.FieldPath}, nil
+	}
+	profile := spec.SourceProfile
+	if profile == "" {
+		profile = defaultProfile
+	}
+	if profile == "" {
+		profile = v1.SourceProfileAlertmanager
+	}
+	path, ok := sourceProfilePaths[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown source profile %s", profile)
+	}
+	return &fieldResolver{defaultPath: path}, nil
+}
+
+func (f *fieldResolver) pathFor(labelSpec v1.LabelSpec) string {
+	path := f.defaultPath
+	if labelSpec.Path != "" {
+		path = labelSpec.Path
+	}
+	return strings.Replace(path, keyPlaceholder, labelSpec.Key, -1)
+}
+
+// buildRuleConditions translates the PagerdutyService's selector groups into the conditions of
+// its ruleset rules, one rule per entry in the returned slice.
+// Rule conditions can't be nested, so a group that has both matchLabels and matchAny becomes
+// one rule per matchAny entry, each also requiring all of matchLabels.
+func buildRuleConditions(spec *v1.PagerdutyServiceSpec, defaultProfile v1.SourceProfile) ([]*pagerduty.RuleConditions, error) {
+	fields, err := newFieldResolver(spec, defaultProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := append([]v1.SelectorSpec{spec.SelectorSpec}, spec.Selectors...)
+	if len(spec.MatchLabels) == 0 && len(spec.MatchAny) == 0 {
+		groups = groups[1:]
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("at least one of matchLabels, matchAny or selectors is required")
+	}
+
+	allConditions := make([]*pagerduty.RuleConditions, 0, len(groups))
+	for idx, group := range groups {
+		matchAll, err := buildSubconditions(group.MatchLabels, fields)
+		if err != nil {
+			return nil, err
+		}
+		matchAny, err := buildSubconditions(group.MatchAny, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case len(matchAll) == 0 && len(matchAny) == 0:
+			return nil, fmt.Errorf("selector group %d is empty", idx)
+		case len(matchAny) == 0:
+			allConditions = append(allConditions, &pagerduty.RuleConditions{Operator: "and", RuleSubconditions: matchAll})
+		case len(matchAll) == 0:
+			allConditions = append(allConditions, &pagerduty.RuleConditions{Operator: "or", RuleSubconditions: matchAny})
+		default:
+			for _, alternative := range matchAny {
+				subconditions := append(append([]*pagerduty.RuleSubcondition{}, matchAll...), alternative)
+				allConditions = append(allConditions, &pagerduty.RuleConditions{Operator: "and", RuleSubconditions: subconditions})
+			}
+		}
+	}
+	return allConditions, nil
+}
+
+func buildSubconditions(labelSpecs []v1.LabelSpec, fields *fieldResolver) ([]*pagerduty.RuleSubcondition, error) {
+	subconditions := make([]*pagerduty.RuleSubcondition, 0, len(labelSpecs))
+	for _, labelSpec := range labelSpecs {
+		subcondition, err := buildSubcondition(labelSpec, fields.pathFor(labelSpec))
+		if err != nil {
+			return nil, err
+		}
+		subconditions = append(subconditions, subcondition)
+	}
+	return subconditions, nil
+}
+
+// buildSubcondition translates a single LabelSpec into a subcondition on the given event field.
+// Since a rule's subconditions can't be nested, set membership (In/NotIn) is expressed
+// as a single regex alternation rather than an OR-group.
+func buildSubcondition(labelSpec v1.LabelSpec, path string) (*pagerduty.RuleSubcondition, error) {
+	if err := validateLabelSpec(labelSpec); err != nil {
+		return nil, err
+	}
+
+	var operator, value string
+	if path == firingPath {
+		operator, value = firingTextCondition(labelSpec)
+	} else {
+		operator, value = fieldCondition(labelSpec)
+	}
+
+	return &pagerduty.RuleSubcondition{
+		Operator: operator,
+		Parameters: &pagerduty.ConditionParameter{
+			Path:  path,
+			Value: value,
+		},
+	}, nil
+}
+
+// firingTextCondition matches a label line in the Alertmanager firing text
+func firingTextCondition(labelSpec v1.LabelSpec) (operator string, value string) {
+	exact := labelSpec.MatchMode == v1.LabelMatchExact
+
+	switch labelSpec.Operator {
+	case v1.LabelOpEquals, "":
+		operator, value = pdOpContains, fmt.Sprintf("%s = %s", labelSpec.Key, labelSpec.Value)
+		if exact {
+			operator, value = pdOpMatches, firingLabelRegex(labelSpec.Key, regexp.QuoteMeta(labelSpec.Value))
+		}
+	case v1.LabelOpNotEquals:
+		operator, value = pdOpNotContains, fmt.Sprintf("%s = %s", labelSpec.Key, labelSpec.Value)
+		if exact {
+			operator, value = pdOpNotMatches, firingLabelRegex(labelSpec.Key, regexp.QuoteMeta(labelSpec.Value))
+		}
+	case v1.LabelOpRegex:
+		operator, value = pdOpMatches, firingLabelRegex(labelSpec.Key, "(?:"+labelSpec.Value+")")
+	case v1.LabelOpExists:
+		operator, value = pdOpMatches, firingLabelKeyRegex(labelSpec.Key)
+	case v1.LabelOpNotExists:
+		operator, value = pdOpNotMatches, firingLabelKeyRegex(labelSpec.Key)
+	case v1.LabelOpIn, v1.LabelOpNotIn:
+		// set membership always matches whole values, whatever the match mode
+		operator = pdOpMatches
+		if labelSpec.Operator == v1.LabelOpNotIn {
+			operator = pdOpNotMatches
+		}
+		value = firingLabelRegex(labelSpec.Key, valueAlternation(labelSpec.Values))
+	}
+	return operator, value
+}
+
+// fieldCondition matches a label that has an event field to itself
+func fieldCondition(labelSpec v1.LabelSpec) (operator string, value string) {
+	switch labelSpec.Operator {
+	case v1.LabelOpEquals, "":
+		return pdOpEquals, labelSpec.Value
+	case v1.LabelOpNotEquals:
+		return pdOpNotEquals, labelSpec.Value
+	case v1.LabelOpRegex:
+		return pdOpMatches, "^(?:" + labelSpec.Value + ")$"
+	case v1.LabelOpExists:
+		return pdOpExists, ""
+	case v1.LabelOpNotExists:
+		return pdOpNotExists, ""
+	case v1.LabelOpIn:
+		return pdOpMatches, "^" + valueAlternation(labelSpec.Values) + "$"
+	case v1.LabelOpNotIn:
+		return pdOpNotMatches, "^" + valueAlternation(labelSpec.Values) + "$"
+	}
+	return "", ""
+}
+
+func validateLabelSpec(labelSpec v1.LabelSpec) error {
+	if labelSpec.Key == "" {
+		return fmt.Errorf("label key is required")
+	}
+	switch labelSpec.Operator {
+	case v1.LabelOpEquals, v1.LabelOpNotEquals, "":
+		if labelSpec.Value == "" {
+			return fmt.Errorf("label %s: a value is required", labelSpec.Key)
+		}
+	case v1.LabelOpRegex:
+		if labelSpec.Value == "" {
+			return fmt.Errorf("label %s: a regex value is required", labelSpec.Key)
+		}
+		if _, err := regexp.Compile(labelSpec.Value); err != nil {
+			return fmt.Errorf("label %s: invalid regex: %v", labelSpec.Key, err)
+		}
+	case v1.LabelOpExists, v1.LabelOpNotExists:
+	case v1.LabelOpIn, v1.LabelOpNotIn:
+		if len(labelSpec.Values) == 0 {
+			return fmt.Errorf("label %s: operator %s requires at least one value", labelSpec.Key, labelSpec.Operator)
+		}
+	default:
+		return fmt.Errorf("label %s: unknown operator %s", labelSpec.Key, labelSpec.Operator)
+	}
+	return nil
+}
+
+// firingLabelRegex matches a whole label line in the firing text.
+// valuePattern is used as-is, so callers must quote literal values.
+func firingLabelRegex(key string, valuePattern string) string {
+	return fmt.Sprintf(`(?m)^[ \t]*-[ \t]*%s = %s[ \t]*$`, regexp.QuoteMeta(key), valuePattern)
+}
+
+// firingLabelKeyRegex matches any label line with the given key
+func firingLabelKeyRegex(key string) string {
+	return fmt.Sprintf(`(?m)^[ \t]*-[ \t]*%s = `, regexp.QuoteMeta(key))
+}
+
+func valueAlternation(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = regexp.QuoteMeta(value)
+	}
+	return "(?:" + strings.Join(quoted, "|") + ")"
+}
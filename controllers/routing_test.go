@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"regexp"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+const firingText = `Labels:
+ - alertname = DiskFull
+ - app = foo
+ - severity = critical
+Annotations:
+ - summary = disk is full
+Source: http://prometheus/graph
+`
+
+// evaluateSubcondition approximates how pagerduty evaluates a firing-text subcondition
+func evaluateSubcondition(g *GomegaWithT, labelSpec v1.LabelSpec, text string) bool {
+	subcondition, err := buildSubcondition(labelSpec, firingPath)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	value := subcondition.Parameters.Value
+	switch subcondition.Operator {
+	case pdOpContains:
+		return regexp.MustCompile(regexp.QuoteMeta(value)).MatchString(text)
+	case pdOpNotContains:
+		return !regexp.MustCompile(regexp.QuoteMeta(value)).MatchString(text)
+	case pdOpMatches:
+		return regexp.MustCompile(value).MatchString(text)
+	case pdOpNotMatches:
+		return !regexp.MustCompile(value).MatchString(text)
+	}
+	panic("unexpected operator " + subcondition.Operator)
+}
+
+// TestExactLabelMatch ensures that exact matching only hits the complete label pair
+func TestExactLabelMatch(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	exact := v1.LabelSpec{Key: "app", Value: "foo", MatchMode: v1.LabelMatchExact}
+	g.Expect(evaluateSubcondition(g, exact, firingText)).To(BeTrue())
+	g.Expect(evaluateSubcondition(g, exact, " - app = foobar\n")).To(BeFalse())
+	g.Expect(evaluateSubcondition(g, exact, " - myapp = foo\n")).To(BeFalse())
+
+	// Regex metacharacters in label values are matched literally
+	dotted := v1.LabelSpec{Key: "host", Value: "a.b", MatchMode: v1.LabelMatchExact}
+	g.Expect(evaluateSubcondition(g, dotted, " - host = a.b\n")).To(BeTrue())
+	g.Expect(evaluateSubcondition(g, dotted, " - host = axb\n")).To(BeFalse())
+}
+
+// TestDefaultLabelMatch ensures the default mode keeps the original substring match
+func TestDefaultLabelMatch(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	subcondition, err := buildSubcondition(v1.LabelSpec{Key: "app", Value: "foo"}, firingPath)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(subcondition.Operator).To(Equal(pdOpContains))
+	g.Expect(subcondition.Parameters.Value).To(Equal("app = foo"))
+
+	conditions, err := buildRuleConditions(&v1.PagerdutyServiceSpec{
+		SelectorSpec: v1.SelectorSpec{
+			MatchLabels: []v1.LabelSpec{{Key: "a", Value: "b"}, {Key: "c", Value: "d", MatchMode: v1.LabelMatchExact}},
+		},
+	}, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conditions).To(HaveLen(1))
+	g.Expect(conditions[0].Operator).To(Equal("and"))
+	g.Expect(conditions[0].RuleSubconditions).To(HaveLen(2))
+}
+
+// TestSelectorGroups checks how selector groups are split into rules
+func TestSelectorGroups(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	foo := v1.LabelSpec{Key: "app", Value: "foo"}
+	bar := v1.LabelSpec{Key: "app", Value: "bar"}
+	prod := v1.LabelSpec{Key: "env", Value: "prod"}
+
+	// matchAny alone is a single "or" rule
+	conditions, err := buildRuleConditions(&v1.PagerdutyServiceSpec{
+		SelectorSpec: v1.SelectorSpec{MatchAny: []v1.LabelSpec{foo, bar}},
+	}, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conditions).To(HaveLen(1))
+	g.Expect(conditions[0].Operator).To(Equal("or"))
+	g.Expect(conditions[0].RuleSubconditions).To(HaveLen(2))
+
+	// matchLabels and matchAny together give one rule per matchAny entry
+	conditions, err = buildRuleConditions(&v1.PagerdutyServiceSpec{
+		SelectorSpec: v1.SelectorSpec{MatchLabels: []v1.LabelSpec{prod}, MatchAny: []v1.LabelSpec{foo, bar}},
+	}, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conditions).To(HaveLen(2))
+	for _, c := range conditions {
+		g.Expect(c.Operator).To(Equal("and"))
+		g.Expect(c.RuleSubconditions).To(HaveLen(2))
+	}
+
+	// additional selector groups get their own rules
+	conditions, err = buildRuleConditions(&v1.PagerdutyServiceSpec{
+		SelectorSpec: v1.SelectorSpec{MatchLabels: []v1.LabelSpec{prod}},
+		Selectors: []v1.SelectorSpec{
+			{MatchLabels: []v1.LabelSpec{foo}},
+			{MatchAny: []v1.LabelSpec{bar}},
+		},
+	}, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conditions).To(HaveLen(3))
+
+	// selectors alone are fine, but there must be something to match on
+	conditions, err = buildRuleConditions(&v1.PagerdutyServiceSpec{Selectors: []v1.SelectorSpec{{MatchLabels: []v1.LabelSpec{foo}}}}, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conditions).To(HaveLen(1))
+
+	_, err = buildRuleConditions(&v1.PagerdutyServiceSpec{}, "")
+	g.Expect(err).To(HaveOccurred())
+	_, err = buildRuleConditions(&v1.PagerdutyServiceSpec{Selectors: []v1.SelectorSpec{{}}}, "")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestLabelOperators(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	cases := []struct {
+		labelSpec v1.LabelSpec
+		expected  bool
+	}{
+		{v1.LabelSpec{Key: "app", Operator: v1.LabelOpNotEquals, Value: "foo"}, false},
+		{v1.LabelSpec{Key: "app", Operator: v1.LabelOpNotEquals, Value: "bar"}, true},
+		{v1.LabelSpec{Key: "app", Operator: v1.LabelOpNotEquals, Value: "fo", MatchMode: v1.LabelMatchExact}, true},
+		{v1.LabelSpec{Key: "app", Operator: v1.LabelOpRegex, Value: "f.o"}, true},
+		{v1.LabelSpec{Key: "app", Operator: v1.LabelOpRegex, Value: "f"}, false},
+		{v1.LabelSpec{Key: "severity", Operator: v1.LabelOpExists}, true},
+		{v1.LabelSpec{Key: "env", Operator: v1.LabelOpExists}, false},
+		{v1.LabelSpec{Key: "env", Operator: v1.LabelOpNotExists}, true},
+		{v1.LabelSpec{Key: "severity", Operator: v1.LabelOpIn, Values: []string{"critical", "page"}}, true},
+		{v1.LabelSpec{Key: "severity", Operator: v1.LabelOpIn, Values: []string{"warning", "page"}}, false},
+		{v1.LabelSpec{Key: "severity", Operator: v1.LabelOpIn, Values: []string{"crit"}, MatchMode: v1.LabelMatchExact}, false},
+		// set membership matches whole values in the default mode too
+		{v1.LabelSpec{Key: "severity", Operator: v1.LabelOpIn, Values: []string{"crit"}}, false},
+		{v1.LabelSpec{Key: "rity", Operator: v1.LabelOpIn, Values: []string{"critical"}}, false},
+		{v1.LabelSpec{Key: "severity", Operator: v1.LabelOpNotIn, Values: []string{"warning", "info"}}, true},
+		{v1.LabelSpec{Key: "severity", Operator: v1.LabelOpNotIn, Values: []string{"critical"}}, false},
+		{v1.LabelSpec{Key: "severity", Operator: v1.LabelOpNotIn, Values: []string{"crit"}}, true},
+	}
+	for _, c := range cases {
+		g.Expect(evaluateSubcondition(g, c.labelSpec, firingText)).To(Equal(c.expected), "%+v", c.labelSpec)
+	}
+}
+
+func TestInvalidLabelSpecs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	invalid := []v1.LabelSpec{
+		{Key: "app"},
+		{Value: "foo"},
+		{Operator: v1.LabelOpExists},
+		{Key: "app", Operator: v1.LabelOpRegex, Value: "("},
+		{Key: "app", Operator: v1.LabelOpIn},
+		{Key: "app", Operator: "Bogus", Value: "foo"},
+	}
+	for _, labelSpec := range invalid {
+		_, err := buildSubcondition(labelSpec, firingPath)
+		g.Expect(err).To(HaveOccurred(), "%+v", labelSpec)
+	}
+}
+
+// TestSourceProfiles checks that labels are matched against the right event fields
+func TestSourceProfiles(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	app := v1.LabelSpec{Key: "app", Value: "foo"}
+	spec := v1.PagerdutyServiceSpec{SelectorSpec: v1.SelectorSpec{MatchLabels: []v1.LabelSpec{app}}}
+	pathAndOperator := func(spec v1.PagerdutyServiceSpec, defaultProfile v1.SourceProfile) (string, string) {
+		conditions, err := buildRuleConditions(&spec, defaultProfile)
+		g.Expect(err).ToNot(HaveOccurred())
+		subcondition := conditions[0].RuleSubconditions[0]
+		return subcondition.Parameters.Path, subcondition.Operator
+	}
+
+	path, operator := pathAndOperator(spec, "")
+	g.Expect(path).To(Equal(firingPath))
+	g.Expect(operator).To(Equal(pdOpContains))
+
+	path, operator = pathAndOperator(spec, v1.SourceProfileGrafana)
+	g.Expect(path).To(Equal("details.labels.app"))
+	g.Expect(operator).To(Equal(pdOpEquals))
+
+	// The resource's profile beats the operator default
+	spec.SourceProfile = v1.SourceProfileCustomDetails
+	path, _ = pathAndOperator(spec, v1.SourceProfileGrafana)
+	g.Expect(path).To(Equal("details.app"))
+
+	// and an explicit field path beats both
+	spec.FieldPath = "details.tags.{key}"
+	path, _ = pathAndOperator(spec, v1.SourceProfileGrafana)
+	g.Expect(path).To(Equal("details.tags.app"))
+
+	// as does a per-label path
+	spec.MatchLabels[0].Path = "source"
+	path, operator = pathAndOperator(spec, "")
+	g.Expect(path).To(Equal("source"))
+	g.Expect(operator).To(Equal(pdOpEquals))
+
+	spec = v1.PagerdutyServiceSpec{SourceProfile: "bogus", SelectorSpec: v1.SelectorSpec{MatchLabels: []v1.LabelSpec{app}}}
+	_, err := buildRuleConditions(&spec, "")
+	g.Expect(err).To(HaveOccurred())
+}
+
+// TestFieldOperators checks the operators used for plain event fields
+func TestFieldOperators(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	subcondition, err := buildSubcondition(v1.LabelSpec{Key: "env", Operator: v1.LabelOpNotExists}, "details.env")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(subcondition.Operator).To(Equal(pdOpNotExists))
+
+	subcondition, err = buildSubcondition(v1.LabelSpec{Key: "severity", Operator: v1.LabelOpIn, Values: []string{"critical", "page"}}, "details.severity")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(subcondition.Operator).To(Equal(pdOpMatches))
+	in := regexp.MustCompile(subcondition.Parameters.Value)
+	g.Expect(in.MatchString("page")).To(BeTrue())
+	g.Expect(in.MatchString("pager")).To(BeFalse())
+}
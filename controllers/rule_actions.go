@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+// extractions rewrite the summary unless told otherwise
+const defaultExtractionTarget = "summary"
+
+// buildRuleActions renders the actions of a routing rule. Every rule routes to the service;
+// the remaining actions come from the PagerdutyService spec.
+// priorityID must already be resolved from the spec's priority name.
+func buildRuleActions(actionsSpec *v1.RuleActionsSpec, serviceID string, priorityID string) (*pagerduty.RuleActions, error) {
+	actions := &pagerduty.RuleActions{
+		Route: &pagerduty.RuleActionParameter{Value: serviceID},
+	}
+	if actionsSpec == nil {
+		return actions, nil
+	}
+	if err := validateRuleActions(actionsSpec); err != nil {
+		return nil, err
+	}
+
+	if actionsSpec.Severity != "" {
+		actions.Severity = &pagerduty.RuleActionParameter{Value: actionsSpec.Severity}
+	}
+	if priorityID != "" {
+		actions.Priority = &pagerduty.RuleActionParameter{Value: priorityID}
+	}
+	if actionsSpec.Annotate != "" {
+		actions.Annotate = &pagerduty.RuleActionParameter{Value: actionsSpec.Annotate}
+	}
+	if actionsSpec.EventAction != "" {
+		actions.EventAction = &pagerduty.RuleActionParameter{Value: actionsSpec.EventAction}
+	}
+	if suppress := actionsSpec.Suppress; suppress != nil {
+		actions.Suppress = &pagerduty.RuleActionSuppress{
+			Value:               true,
+			ThresholdValue:      suppress.ThresholdValue,
+			ThresholdTimeUnit:   suppress.ThresholdTimeUnit,
+			ThresholdTimeAmount: suppress.ThresholdTimeAmount,
+		}
+	}
+	for _, extraction := range actionsSpec.Extractions {
+		target := extraction.Target
+		if target == "" {
+			target = defaultExtractionTarget
+		}
+		actions.Extractions = append(actions.Extractions, &pagerduty.RuleActionExtraction{
+			Target: target,
+			Source: extraction.Source,
+			Regex:  extraction.Regex,
+		})
+	}
+
+	return actions, nil
+}
+
+func validateRuleActions(actionsSpec *v1.RuleActionsSpec) error {
+	if suppress := actionsSpec.Suppress; suppress != nil {
+		hasThreshold := suppress.ThresholdValue != 0 || suppress.ThresholdTimeUnit != "" || suppress.ThresholdTimeAmount != 0
+		completeThreshold := suppress.ThresholdValue > 0 && suppress.ThresholdTimeUnit != "" && suppress.ThresholdTimeAmount > 0
+		if hasThreshold && !completeThreshold {
+			return fmt.Errorf("suppress: thresholdValue, thresholdTimeUnit and thresholdTimeAmount must be set together")
+		}
+	}
+	for _, extraction := range actionsSpec.Extractions {
+		if extraction.Source == "" {
+			return fmt.Errorf("extraction: a source is required")
+		}
+		re, err := regexp.Compile(extraction.Regex)
+		if err != nil {
+			return fmt.Errorf("extraction from %s: invalid regex: %v", extraction.Source, err)
+		}
+		if re.NumSubexp() < 1 {
+			return fmt.Errorf("extraction from %s: regex needs a capture group", extraction.Source)
+		}
+	}
+	return nil
+}
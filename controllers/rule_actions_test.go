@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+func TestBuildRuleActions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// Without any actions in the spec, the rule only routes
+	actions, err := buildRuleActions(nil, "SVC1", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(actions.Route.Value).To(Equal("SVC1"))
+	g.Expect(actions.Severity).To(BeNil())
+	g.Expect(actions.Suppress).To(BeNil())
+
+	actions, err = buildRuleActions(&v1.RuleActionsSpec{
+		Severity:    "warning",
+		Priority:    "P2",
+		Annotate:    "see the runbook",
+		EventAction: "trigger",
+		Suppress:    &v1.SuppressSpec{ThresholdValue: 3, ThresholdTimeUnit: "minutes", ThresholdTimeAmount: 5},
+		Extractions: []v1.ExtractionSpec{{Source: "details.host", Regex: "(.*)\\.example\\.com"}},
+	}, "SVC1", "PRIO2")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(actions.Route.Value).To(Equal("SVC1"))
+	g.Expect(actions.Severity.Value).To(Equal("warning"))
+	g.Expect(actions.Priority.Value).To(Equal("PRIO2"))
+	g.Expect(actions.Annotate.Value).To(Equal("see the runbook"))
+	g.Expect(actions.EventAction.Value).To(Equal("trigger"))
+	g.Expect(actions.Suppress.Value).To(BeTrue())
+	g.Expect(actions.Suppress.ThresholdValue).To(Equal(3))
+	g.Expect(actions.Extractions).To(HaveLen(1))
+	g.Expect(actions.Extractions[0].Target).To(Equal(defaultExtractionTarget))
+}
+
+func TestInvalidRuleActions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	invalid := []v1.RuleActionsSpec{
+		{Suppress: &v1.SuppressSpec{ThresholdValue: 3}},
+		{Extractions: []v1.ExtractionSpec{{Source: "summary", Regex: "("}}},
+		{Extractions: []v1.ExtractionSpec{{Source: "summary", Regex: "no capture group"}}},
+		{Extractions: []v1.ExtractionSpec{{Regex: "(.*)"}}},
+	}
+	for _, actionsSpec := range invalid {
+		_, err := buildRuleActions(&actionsSpec, "SVC1", "")
+		g.Expect(err).To(HaveOccurred(), "%+v", actionsSpec)
+	}
+}
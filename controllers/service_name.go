@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	"github.com/dchest/uniuri"
+
+	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
+)
+
+// DefaultServiceNameTemplate names services the way the operator always has: the prefix and the resource name
+const DefaultServiceNameTemplate = "{{.Prefix}}-{{.Name}}"
+
+const (
+	// longest service name pagerduty accepts
+	maxServiceNameLen = 255
+	// length of the random suffix added to service names that are already taken
+	serviceNameSuffixLen = 6
+	// how many random suffixes to try before giving up
+	randomServiceNameAttempts = 3
+)
+
+var (
+	defaultServiceNameTemplate = template.Must(ParseServiceNameTemplate(DefaultServiceNameTemplate))
+
+	// an empty field and the separator after it, or else the one before it
+	emptyFieldThenSeparator = regexp.MustCompile(emptyField + `[- ]`)
+	separatorThenEmptyField = regexp.MustCompile(`[- ]?` + emptyField)
+)
+
+// emptyField stands in for empty fields while rendering, to find the separators they leave behind
+const emptyField = "\x00"
+
+// ServiceNameData is what service name templates are rendered with
+type ServiceNameData struct {
+	// Prefix is the operator's -service-prefix
+	Prefix string
+	// Cluster is the operator's -cluster-name
+	Cluster   string
+	Namespace string
+	Name      string
+}
+
+// ParseServiceNameTemplate parses a service name template, and checks it renders a valid name
+func ParseServiceNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("serviceName").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid service name template %q: %v", text, err)
+	}
+	sample := ServiceNameData{Prefix: "prefix", Cluster: "cluster", Namespace: "namespace", Name: "name"}
+	if _, err = renderServiceName(tmpl, sample); err != nil {
+		return nil, fmt.Errorf("Invalid service name template %q: %v", text, err)
+	}
+	return tmpl, nil
+}
+
+// renderServiceName renders a service name template, dropping the separator an empty field leaves behind,
+// like the leading dash of "-name" without a prefix. Separators that are part of a field's value are kept,
+// and so is everything rendered by templates that handle empty fields themselves.
+func renderServiceName(tmpl *template.Template, data ServiceNameData) (string, error) {
+	name, err := executeServiceName(tmpl, data)
+	if err != nil {
+		return "", err
+	}
+
+	marked := data
+	for _, field := range []*string{&marked.Prefix, &marked.Cluster, &marked.Namespace, &marked.Name} {
+		if *field == "" {
+			*field = emptyField
+		}
+	}
+	markedName, err := executeServiceName(tmpl, marked)
+	if err == nil && strings.Replace(markedName, emptyField, "", -1) == name {
+		markedName = emptyFieldThenSeparator.ReplaceAllString(markedName, "")
+		name = separatorThenEmptyField.ReplaceAllString(markedName, "")
+	}
+	return name, validateServiceName(name)
+}
+
+func executeServiceName(tmpl *template.Template, data ServiceNameData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validateServiceName checks a name against pagerduty's limits
+func validateServiceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Service name is empty")
+	}
+	if strings.TrimSpace(name) != name {
+		return fmt.Errorf("Service name %q starts or ends with whitespace", name)
+	}
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("Service name %q is not valid UTF-8", name)
+	}
+	if utf8.RuneCountInString(name) > maxServiceNameLen {
+		return fmt.Errorf("Service name %q is longer than %d characters", name, maxServiceNameLen)
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return fmt.Errorf("Service name %q contains control characters", name)
+		}
+	}
+	return nil
+}
+
+// desiredServiceName returns the resource's explicit service name, or renders its service name template,
+// or the operator's
+func (r *PagerdutyServiceReconciler) desiredServiceName(kubeService *v1.PagerdutyService) (string, error) {
+	if name := kubeService.Spec.ServiceName; name != "" {
+		if kubeService.Spec.ServiceNamePrefixed && r.ServicePrefix != "" {
+			name = r.ServicePrefix + "-" + name
+		}
+		return name, validateServiceName(name)
+	}
+
+	tmpl := r.ServiceNameTemplate
+	if kubeService.Spec.ServiceNameTemplate != "" {
+		var err error
+		if tmpl, err = ParseServiceNameTemplate(kubeService.Spec.ServiceNameTemplate); err != nil {
+			return "", err
+		}
+	}
+	if tmpl == nil {
+		tmpl = defaultServiceNameTemplate
+	}
+	return renderServiceName(tmpl, ServiceNameData{
+		Prefix:    r.ServicePrefix,
+		Cluster:   r.ClusterName,
+		Namespace: kubeService.Namespace,
+		Name:      kubeService.Name,
+	})
+}
+
+// createPdService creates the pagerduty service, working around names that are already taken
+func (r *PagerdutyServiceReconciler) createPdService(kubeService *v1.PagerdutyService, pdService pagerduty.Service, name string) (*pagerduty.Service, error) {
+	return r.saveWithFreeName(kubeService, pdService, name, r.PdClient.CreateService)
+}
+
+// renamePdService updates the pagerduty service with a new name, working around names that are already taken
+func (r *PagerdutyServiceReconciler) renamePdService(kubeService *v1.PagerdutyService, pdService pagerduty.Service, name string) (*pagerduty.Service, error) {
+	oldName := pdService.Name
+	renamed, err := r.saveWithFreeName(kubeService, pdService, name, r.PdClient.UpdateService)
+	if err == nil {
+		msg := fmt.Sprintf("Renamed service %s to %s", oldName, renamed.Name)
+		logger.Info(msg)
+		r.EventRecorder.Event(kubeService, "Normal", "Renamed", msg)
+	}
+	return renamed, err
+}
+
+// saveWithFreeName saves the service under baseName, or the first free alternative.
+// Service names are account-wide, so it first tries adding the resource's namespace,
+// and then random suffixes. An explicit spec.serviceName is only ever used as is.
+func (r *PagerdutyServiceReconciler) saveWithFreeName(kubeService *v1.PagerdutyService, pdService pagerduty.Service, baseName string,
+	save func(pagerduty.Service) (*pagerduty.Service, error)) (*pagerduty.Service, error) {
+	names := []string{baseName}
+	if kubeService.Spec.ServiceName == "" {
+		if !strings.Contains(baseName, kubeService.Namespace) {
+			names = append(names, withNameSuffix(baseName, kubeService.Namespace))
+		}
+		for i := 0; i < randomServiceNameAttempts; i++ {
+			names = append(names, withNameSuffix(baseName, uniuri.NewLen(serviceNameSuffixLen)))
+		}
+	}
+
+	var err error
+	for _, name := range names {
+		pdService.Name = name
+		var saved *pagerduty.Service
+		saved, err = save(pdService)
+		if err == nil {
+			if name != baseName {
+				msg := fmt.Sprintf("Service name %s is already taken in pagerduty, used %s instead", baseName, name)
+				logger.Info(msg)
+				r.EventRecorder.Event(kubeService, "Warning", "ServiceNameTaken", msg)
+			}
+			return saved, nil
+		}
+		if !pdhelpers.IsNameTaken(err) {
+			return nil, err
+		}
+		logger.Info("Service name is already taken", "name", name)
+	}
+	return nil, err
+}
+
+// withNameSuffix appends a suffix to a service name, shortening the name to stay within pagerduty's limit
+func withNameSuffix(name string, suffix string) string {
+	runes := []rune(name)
+	if keep := maxServiceNameLen - utf8.RuneCountInString(suffix) - 1; len(runes) > keep {
+		runes = runes[:keep]
+	}
+	return string(runes) + "-" + suffix
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	v1 "pagerduty-operator/api/v1"
+	"pagerduty-operator/pdhelpers"
+)
+
+// TestServiceNameCollisions ensures taken service names get a suffix instead of failing
+func TestServiceNameCollisions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "team-a"}}
+	pdClient := &PagerdutyClientMock{}
+	r := newTestReconciler()
+	r.PdClient = pdClient
+	r.ServicePrefix = "prod"
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+
+	created, err := r.createPdService(kubeService, pagerduty.Service{}, "prod-api")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(created.Name).To(Equal("prod-api"))
+	g.Expect(recorder.Events).To(BeEmpty())
+
+	// another namespace already has an "api" service
+	pdClient.takenNames = map[string]bool{"prod-api": true}
+	created, err = r.createPdService(kubeService, pagerduty.Service{}, "prod-api")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(created.Name).To(Equal("prod-api-team-a"))
+	g.Expect(<-recorder.Events).To(ContainSubstring("used prod-api-team-a instead"))
+
+	// and so does this one, from an earlier install
+	pdClient.takenNames["prod-api-team-a"] = true
+	created, err = r.createPdService(kubeService, pagerduty.Service{}, "prod-api")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(strings.HasPrefix(created.Name, "prod-api-")).To(BeTrue())
+	g.Expect(created.Name).To(HaveLen(len("prod-api-") + serviceNameSuffixLen))
+	<-recorder.Events
+
+	// other errors aren't retried
+	// an explicit name is never changed
+	kubeService.Spec.ServiceName = "api"
+	pdClient.takenNames["api"] = true
+	_, err = r.createPdService(kubeService, pagerduty.Service{}, "api")
+	g.Expect(pdhelpers.IsNameTaken(err)).To(BeTrue())
+	kubeService.Spec.ServiceName = ""
+
+	failing := &failingCreateMock{}
+	r.PdClient = failing
+	_, err = r.createPdService(kubeService, pagerduty.Service{}, "prod-api")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(failing.calls).To(Equal(1))
+}
+
+type failingCreateMock struct {
+	PagerdutyClientMock
+	calls int
+}
+
+func (m *failingCreateMock) CreateService(service pagerduty.Service) (*pagerduty.Service, error) {
+	m.calls++
+	return nil, notFoundError()
+}
+
+func TestServiceNameTemplates(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "team-a"}}
+	r := &PagerdutyServiceReconciler{}
+
+	// without a prefix, the default template is just the name
+	name, err := r.desiredServiceName(kubeService)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(name).To(Equal("api"))
+
+	r.ServicePrefix = "prod"
+	r.ServiceNameTemplate, err = ParseServiceNameTemplate("{{.Prefix}}-{{.Cluster}}-{{.Namespace}}-{{.Name}}")
+	g.Expect(err).ToNot(HaveOccurred())
+	name, err = r.desiredServiceName(kubeService)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(name).To(Equal("prod-team-a-api"))
+
+	r.ClusterName = "us-east"
+	name, _ = r.desiredServiceName(kubeService)
+	g.Expect(name).To(Equal("prod-us-east-team-a-api"))
+
+	// dashes that are part of the prefix stay, as they always have
+	r.ServicePrefix = "team-"
+	r.ServiceNameTemplate = nil
+	kubeService.Name = "foo"
+	name, _ = r.desiredServiceName(kubeService)
+	g.Expect(name).To(Equal("team--foo"))
+	r.ServicePrefix = "prod"
+	kubeService.Name = "api"
+
+	// templates that handle empty fields themselves are rendered as is
+	r.ClusterName = ""
+	r.ServiceNameTemplate, err = ParseServiceNameTemplate("{{.Name}}{{if not .Cluster}} (no cluster){{end}}")
+	g.Expect(err).ToNot(HaveOccurred())
+	name, _ = r.desiredServiceName(kubeService)
+	g.Expect(name).To(Equal("api (no cluster)"))
+	r.ClusterName = "us-east"
+
+	// the resource's own template wins
+	kubeService.Spec.ServiceNameTemplate = "{{.Name}} ({{.Namespace}})"
+	name, _ = r.desiredServiceName(kubeService)
+	g.Expect(name).To(Equal("api (team-a)"))
+
+	kubeService.Spec.ServiceNameTemplate = "{{.Name"
+	_, err = r.desiredServiceName(kubeService)
+	g.Expect(err).To(HaveOccurred())
+
+	// an explicit name beats any template, and only gets the prefix when asked to
+	kubeService.Spec.ServiceName = "Payments API"
+	name, err = r.desiredServiceName(kubeService)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(name).To(Equal("Payments API"))
+
+	kubeService.Spec.ServiceNamePrefixed = true
+	name, _ = r.desiredServiceName(kubeService)
+	g.Expect(name).To(Equal("prod-Payments API"))
+
+	kubeService.Spec.ServiceName = "Payments API "
+	_, err = r.desiredServiceName(kubeService)
+	g.Expect(err).To(HaveOccurred())
+	kubeService.Spec = v1.PagerdutyServiceSpec{}
+
+	for _, invalid := range []string{"{{.Team}}", "", " - ", "{{.Name}}\n", strings.Repeat("x", maxServiceNameLen+1)} {
+		_, err = ParseServiceNameTemplate(invalid)
+		g.Expect(err).To(HaveOccurred(), invalid)
+	}
+
+	g.Expect(withNameSuffix(strings.Repeat("x", maxServiceNameLen), "abc")).To(HaveLen(maxServiceNameLen))
+}
+
+// TestRenameService ensures existing services are renamed when the template's output changes
+func TestRenameService(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "team-a"},
+		Spec: v1.PagerdutyServiceSpec{
+			EscalationPolicy: "EP1",
+			SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+		},
+		Status: v1.PagerdutyServiceStatus{ServiceID: "SVC", ServiceName: "api", BaseServiceName: "api", RulesetID: "RS1"},
+	}
+	pdClient := &PagerdutyClientMock{service: &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "SVC"}, Name: "api"}}
+	nameTemplate, err := ParseServiceNameTemplate("{{.Namespace}}-{{.Name}}")
+	g.Expect(err).ToNot(HaveOccurred())
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+	r.ServiceNameTemplate = nameTemplate
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+
+	name := types.NamespacedName{Name: "api", Namespace: "team-a"}
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var reconciled v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	g.Expect(pdClient.service.ID).To(Equal("SVC"))
+	g.Expect(pdClient.service.Name).To(Equal("team-a-api"))
+	g.Expect(reconciled.Status.ServiceName).To(Equal("team-a-api"))
+	g.Expect(reconciled.Status.BaseServiceName).To(Equal("team-a-api"))
+	g.Expect(<-recorder.Events).To(ContainSubstring("Renamed service api to team-a-api"))
+
+	// a name changed in the pagerduty UI is drift, and renamed back
+	pdClient.service.Name = "edited"
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pdClient.service.Name).To(Equal("team-a-api"))
+}
+
+// TestRenameMigration ensures renames are spread out, and everything else is still synced meanwhile
+func TestRenameMigration(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	kubeService := &v1.PagerdutyService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "team-a"},
+		Spec: v1.PagerdutyServiceSpec{
+			Description:      "new description",
+			EscalationPolicy: "EP1",
+			SelectorSpec:     v1.SelectorSpec{MatchLabels: []v1.LabelSpec{{Key: "app", Value: "foo"}}},
+		},
+		Status: v1.PagerdutyServiceStatus{ServiceID: "SVC", ServiceName: "api", BaseServiceName: "api", RulesetID: "RS1"},
+	}
+	pdClient := &PagerdutyClientMock{service: &pagerduty.Service{APIObject: pagerduty.APIObject{ID: "SVC"}, Name: "api"}}
+
+	now := time.Now()
+	renames := NewRenameMigration(1)
+	renames.now = func() time.Time { return now }
+	// another service used up this minute's rename
+	g.Expect(renames.Wait(types.NamespacedName{Name: "other"})).To(BeZero())
+	renames.Done(types.NamespacedName{Name: "other"}, true)
+
+	r := newTestReconciler(kubeService)
+	r.PdClient = pdClient
+	r.ServicePrefix = "prod"
+	r.Renames = renames
+
+	name := types.NamespacedName{Name: "api", Namespace: "team-a"}
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically("~", time.Minute, time.Second))
+	g.Expect(renames.Pending()).To(Equal(1))
+	g.Expect(pdClient.service.Name).To(Equal("api"))
+	g.Expect(withoutOwnerMarker(pdClient.service.Description)).To(Equal("new description"))
+
+	var reconciled v1.PagerdutyService
+	g.Expect(r.Get(context.Background(), name, &reconciled)).To(Succeed())
+	g.Expect(reconciled.Status.ServiceName).To(Equal("api"))
+	g.Expect(reconciled.Status.BaseServiceName).To(Equal("api"))
+
+	// asking again doesn't lose its place in line
+	now = now.Add(30 * time.Second)
+	g.Expect(renames.Wait(name)).To(BeNumerically("~", 30*time.Second, time.Second))
+
+	now = now.Add(30 * time.Second)
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: name})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pdClient.service.Name).To(Equal("prod-api"))
+	g.Expect(renames.Pending()).To(BeZero())
+}
@@ -50,6 +50,13 @@ var fakeEventRecorder = record.NewFakeRecorder(10)
 var pagerdutyServiceReconciler PagerdutyServiceReconciler
 var pdClientMock PagerdutyClientMock
 var fakeRulesetClient pdhelpers.FakeRulesetClient
+var fakeServiceClient pdhelpers.FakeServiceClient
+
+// fakeRulesetManagerClient serves rulesets and services from the fakes above
+type fakeRulesetManagerClient struct {
+	pdhelpers.FakeRulesetClient
+	pdhelpers.FakeServiceClient
+}
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -97,6 +104,8 @@ var _ = BeforeSuite(func(done Done) {
 		PdClient:      &pdClientMock,
 		RulesetID:     rulesetID,
 		ServicePrefix: servicePrefix,
+		// a recorder without a channel drops events, so the ruleset tests can't fill up the shared one
+		EventRecorder: &record.FakeRecorder{},
 	}
 	err = pagerdutyServiceReconciler.SetupWithManager((k8sManager))
 	Expect(err).ToNot(HaveOccurred())
@@ -106,11 +115,12 @@ var _ = BeforeSuite(func(done Done) {
 	**/
 	By("Setting up Ruleset Reconciler")
 	fakeRulesetClient = pdhelpers.NewFakeRulesetClient()
+	fakeServiceClient = pdhelpers.NewFakeServiceClient()
 	reconciler := PagerdutyRulesetReconciler{
 		Client:          k8sManager.GetClient(),
 		Log:             ctrl.Log.WithName("controllers").WithName("PagerdutyRuleset"),
 		EventRecorder:   fakeEventRecorder,
-		PagerDutyClient: fakeRulesetClient,
+		PagerDutyClient: fakeRulesetManagerClient{fakeRulesetClient, fakeServiceClient},
 	}
 	err = reconciler.SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	pagerduty "github.com/PagerDuty/go-pagerduty"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+const maxWeeklyDuration = 7 * 24 * time.Hour
+
+// pagerduty numbers weekdays from Monday (1) to Sunday (7)
+var weekdayNumbers = map[v1.Weekday]int{
+	"Monday":    1,
+	"Tuesday":   2,
+	"Wednesday": 3,
+	"Thursday":  4,
+	"Friday":    5,
+	"Saturday":  6,
+	"Sunday":    7,
+}
+
+// buildTimeFrame renders the rule time frame, along with a human readable description for the status.
+// A nil spec means the rules are always active.
+func buildTimeFrame(spec *v1.TimeFrameSpec) (*pagerduty.RuleTimeFrame, string, error) {
+	if spec == nil {
+		return nil, "", nil
+	}
+
+	switch {
+	case spec.ActiveBetween != nil && spec.ScheduledWeekly != nil:
+		return nil, "", fmt.Errorf("timeFrame: only one of activeBetween and scheduledWeekly may be set")
+	case spec.ActiveBetween != nil:
+		return buildActiveBetween(spec.ActiveBetween)
+	case spec.ScheduledWeekly != nil:
+		return buildScheduledWeekly(spec.ScheduledWeekly)
+	}
+	return nil, "", fmt.Errorf("timeFrame: one of activeBetween and scheduledWeekly is required")
+}
+
+func buildActiveBetween(spec *v1.ActiveBetweenSpec) (*pagerduty.RuleTimeFrame, string, error) {
+	start, end := spec.Start.Time, spec.End.Time
+	if !end.After(start) {
+		return nil, "", fmt.Errorf("timeFrame: activeBetween must end after it starts")
+	}
+
+	timeFrame := &pagerduty.RuleTimeFrame{
+		ActiveBetween: &pagerduty.ActiveBetween{
+			StartTime: toMillis(start),
+			EndTime:   toMillis(end),
+		},
+	}
+	description := fmt.Sprintf("active between %s and %s", start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+	return timeFrame, description, nil
+}
+
+func buildScheduledWeekly(spec *v1.ScheduledWeeklySpec) (*pagerduty.RuleTimeFrame, string, error) {
+	if spec.Timezone == "" {
+		return nil, "", fmt.Errorf("timeFrame: scheduledWeekly requires a timezone")
+	}
+	location, err := time.LoadLocation(spec.Timezone)
+	if err != nil {
+		return nil, "", fmt.Errorf("timeFrame: unknown timezone %s", spec.Timezone)
+	}
+
+	startOfDay, err := time.Parse("15:04", spec.StartTime)
+	if err != nil {
+		return nil, "", fmt.Errorf("timeFrame: startTime %s is not in HH:MM format", spec.StartTime)
+	}
+
+	duration := spec.Duration.Duration
+	if duration <= 0 || duration > maxWeeklyDuration {
+		return nil, "", fmt.Errorf("timeFrame: duration must be positive and at most %s", maxWeeklyDuration)
+	}
+
+	if len(spec.Weekdays) == 0 {
+		return nil, "", fmt.Errorf("timeFrame: scheduledWeekly requires at least one weekday")
+	}
+	weekdays := make([]int, 0, len(spec.Weekdays))
+	seen := make(map[int]bool)
+	for _, weekday := range spec.Weekdays {
+		number, ok := weekdayNumbers[weekday]
+		if !ok {
+			return nil, "", fmt.Errorf("timeFrame: unknown weekday %s", weekday)
+		}
+		if !seen[number] {
+			seen[number] = true
+			weekdays = append(weekdays, number)
+		}
+	}
+	sort.Ints(weekdays)
+
+	// Pagerduty wants the weekly start time as a timestamp, and only uses its time of day in the
+	// rule's timezone. Pinning the date keeps the rendered rule the same from one reconcile to the next.
+	start := time.Date(2021, time.January, 4, startOfDay.Hour(), startOfDay.Minute(), 0, 0, location)
+	timeFrame := &pagerduty.RuleTimeFrame{
+		ScheduledWeekly: &pagerduty.ScheduledWeekly{
+			Weekdays:  weekdays,
+			Timezone:  spec.Timezone,
+			StartTime: toMillis(start),
+			Duration:  int(duration / time.Millisecond),
+		},
+	}
+
+	dayNames := make([]string, len(weekdays))
+	for i, number := range weekdays {
+		// time.Weekday counts from Sunday (0)
+		dayNames[i] = time.Weekday(number % 7).String()[:3]
+	}
+	description := fmt.Sprintf("weekly on %s from %s for %s (%s)",
+		strings.Join(dayNames, ","), spec.StartTime, duration, spec.Timezone)
+	return timeFrame, description, nil
+}
+
+func toMillis(t time.Time) int {
+	return int(t.UnixNano() / int64(time.Millisecond))
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "pagerduty-operator/api/v1"
+)
+
+func TestScheduledWeeklyTimeFrame(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	timeFrame, description, err := buildTimeFrame(&v1.TimeFrameSpec{
+		ScheduledWeekly: &v1.ScheduledWeeklySpec{
+			Weekdays:  []v1.Weekday{"Friday", "Monday", "Monday"},
+			Timezone:  "America/New_York",
+			StartTime: "09:30",
+			Duration:  metav1.Duration{Duration: 8 * time.Hour},
+		},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	weekly := timeFrame.ScheduledWeekly
+	g.Expect(weekly.Weekdays).To(Equal([]int{1, 5}))
+	g.Expect(weekly.Timezone).To(Equal("America/New_York"))
+	g.Expect(weekly.Duration).To(Equal(8 * 60 * 60 * 1000))
+	g.Expect(timeFrame.ActiveBetween).To(BeNil())
+	g.Expect(description).To(Equal("weekly on Mon,Fri from 09:30 for 8h0m0s (America/New_York)"))
+
+	// The start time is 09:30 local time
+	location, _ := time.LoadLocation("America/New_York")
+	start := time.Unix(0, int64(weekly.StartTime)*int64(time.Millisecond)).In(location)
+	g.Expect(start.Hour()).To(Equal(9))
+	g.Expect(start.Minute()).To(Equal(30))
+}
+
+func TestActiveBetweenTimeFrame(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	start := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	timeFrame, description, err := buildTimeFrame(&v1.TimeFrameSpec{
+		ActiveBetween: &v1.ActiveBetweenSpec{
+			Start: metav1.NewTime(start),
+			End:   metav1.NewTime(start.Add(time.Hour)),
+		},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(timeFrame.ActiveBetween.EndTime - timeFrame.ActiveBetween.StartTime).To(Equal(60 * 60 * 1000))
+	g.Expect(description).To(Equal("active between 2021-03-01T12:00:00Z and 2021-03-01T13:00:00Z"))
+
+	timeFrame, _, err = buildTimeFrame(nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(timeFrame).To(BeNil())
+}
+
+func TestInvalidTimeFrames(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := metav1.Now()
+	weekly := func(timezone string, startTime string, duration time.Duration, weekdays ...v1.Weekday) *v1.ScheduledWeeklySpec {
+		return &v1.ScheduledWeeklySpec{Weekdays: weekdays, Timezone: timezone, StartTime: startTime, Duration: metav1.Duration{Duration: duration}}
+	}
+	invalid := []v1.TimeFrameSpec{
+		{},
+		{ActiveBetween: &v1.ActiveBetweenSpec{Start: now, End: now}},
+		{ActiveBetween: &v1.ActiveBetweenSpec{Start: now, End: now}, ScheduledWeekly: weekly("UTC", "09:00", time.Hour, "Monday")},
+		{ScheduledWeekly: weekly("Mars/Olympus_Mons", "09:00", time.Hour, "Monday")},
+		{ScheduledWeekly: weekly("", "09:00", time.Hour, "Monday")},
+		{ScheduledWeekly: weekly("UTC", "9am", time.Hour, "Monday")},
+		{ScheduledWeekly: weekly("UTC", "09:00", 0, "Monday")},
+		{ScheduledWeekly: weekly("UTC", "09:00", 8*24*time.Hour, "Monday")},
+		{ScheduledWeekly: weekly("UTC", "09:00", time.Hour)},
+		{ScheduledWeekly: weekly("UTC", "09:00", time.Hour, "Caturday")},
+	}
+	for _, spec := range invalid {
+		_, _, err := buildTimeFrame(&spec)
+		g.Expect(err).To(HaveOccurred(), "%+v", spec)
+	}
+}
@@ -20,22 +20,28 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/PagerDuty/go-pagerduty"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	corev1 "pagerduty-operator/api/v1"
 	"pagerduty-operator/controllers"
+	"pagerduty-operator/pdhelpers"
 	// +kubebuilder:scaffold:imports
 )
 
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
+	// envErrors are the environment variables that couldn't be parsed, reported once the logger is set up
+	envErrors []error
 )
 
 func init() {
@@ -50,7 +56,23 @@ func main() {
 	var enableLeaderElection bool
 	var pagerdutyAPIKey string
 	var servicePrefix string
+	var serviceNameTemplate string
+	var clusterName string
+	var clusterID string
+	var renameRate float64
+	var deletionPolicy string
+	var openIncidentsPolicy string
+	var fromEmail string
+	var cleanupTimeout string
+	var orphanLedger string
+	var orphanLedgerNamespace string
+	var gcInterval string
+	var gcDryRun bool
 	var rulesetID string
+	var sourceProfile string
+	var resyncInterval string
+	var apiRateLimit float64
+	var apiBurst int
 
 	flag.StringVar(&metricsAddr, "metrics-addr", getEnv("METRICS_ADDR", ":8080"), "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
@@ -58,18 +80,103 @@ func main() {
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&pagerdutyAPIKey, "api-key", getEnv("PAGERDUTY_API_KEY", ""), "Authorization key for the pagerduty API.")
 	flag.StringVar(&servicePrefix, "service-prefix", getEnv("PAGERDUTY_SERVICE_PREFIX", ""), "Prefix to be added to Pagerduty Service names")
-	flag.StringVar(&rulesetID, "ruleset", getEnv("PAGERDUTY_RULESET_ID", ""), "ID of the ruleset to append routing rules to.")
+	flag.StringVar(&serviceNameTemplate, "service-name-template", getEnv("PAGERDUTY_SERVICE_NAME_TEMPLATE", controllers.DefaultServiceNameTemplate),
+		"Go template for Pagerduty Service names, using .Prefix, .Cluster, .Namespace and .Name.")
+	flag.StringVar(&clusterName, "cluster-name", getEnv("PAGERDUTY_CLUSTER_NAME", ""), "Name of this cluster, for service name templates.")
+	flag.StringVar(&clusterID, "cluster-id", getEnv("PAGERDUTY_CLUSTER_ID", ""),
+		"Identifies this cluster in the ownership markers of the Pagerduty Services and rules it manages. Defaults to -cluster-name.")
+	flag.Float64Var(&renameRate, "service-rename-rate", getEnvFloat("PAGERDUTY_SERVICE_RENAME_RATE", 0),
+		"Services renamed per minute when their names change, e.g. after changing -service-prefix. 0 renames them right away.")
+	flag.StringVar(&deletionPolicy, "deletion-policy", getEnv("PAGERDUTY_DELETION_POLICY", string(corev1.DeletionPolicyDelete)),
+		"What happens to the Pagerduty Services of deleted PagerdutyServices without a deletionPolicy. "+
+			"One of Delete, Retain or Disable. Adopted services are always retained by default.")
+	flag.StringVar(&openIncidentsPolicy, "open-incidents-policy", getEnv("PAGERDUTY_OPEN_INCIDENTS_POLICY", string(corev1.OpenIncidentsBlock)),
+		"What to do when a deleted PagerdutyService's service has open incidents. One of Block, Resolve or Ignore.")
+	flag.StringVar(&fromEmail, "from-email", getEnv("PAGERDUTY_FROM_EMAIL", ""), "Email address of the Pagerduty user open incidents are resolved as.")
+	flag.StringVar(&cleanupTimeout, "cleanup-timeout", getEnv("PAGERDUTY_CLEANUP_TIMEOUT", "0"),
+		"How long cleaning up a deleted resource in Pagerduty may fail before its finalizer is removed anyway, e.g. 24h. 0 waits forever.")
+	flag.StringVar(&orphanLedger, "orphan-ledger", getEnv("PAGERDUTY_ORPHAN_LEDGER", "pagerduty-operator-orphans"),
+		"Name of the ConfigMap recording the Pagerduty objects left behind when a finalizer is removed without cleaning up.")
+	flag.StringVar(&orphanLedgerNamespace, "orphan-ledger-namespace", getEnv("POD_NAMESPACE", "default"), "Namespace of the orphan ledger ConfigMap.")
+	flag.StringVar(&gcInterval, "gc-interval", getEnv("PAGERDUTY_GC_INTERVAL", "0"),
+		"How often to look for Pagerduty Services and rules this cluster created that no resource uses anymore, e.g. 1h. "+
+			"0 disables the garbage collector. Needs -cluster-id.")
+	flag.BoolVar(&gcDryRun, "gc-dry-run", getEnvBool("PAGERDUTY_GC_DRY_RUN", true),
+		"Only report what the garbage collector would delete.")
+	flag.StringVar(&rulesetID, "ruleset", getEnv("PAGERDUTY_RULESET_ID", ""), "ID of the ruleset to append routing rules to, for PagerdutyServices without a rulesetRef.")
+	flag.StringVar(&sourceProfile, "source-profile", getEnv("PAGERDUTY_SOURCE_PROFILE", string(corev1.SourceProfileAlertmanager)),
+		"Default event source profile, which decides the event fields labels are matched against. "+
+			"One of alertmanager, events-v2-custom-details or grafana.")
+	flag.StringVar(&resyncInterval, "resync-interval", getEnv("PAGERDUTY_RESYNC_INTERVAL", "10m"),
+		"How often to check pagerduty for changes made outside the operator, e.g. 10m. 0 disables the resync.")
+	flag.Float64Var(&apiRateLimit, "api-rate-limit", getEnvFloat("PAGERDUTY_API_RATE_LIMIT", 10),
+		"Requests per second the operator makes to the pagerduty API, across all controllers.")
+	flag.IntVar(&apiBurst, "api-burst", getEnvInt("PAGERDUTY_API_BURST", 20),
+		"Requests the operator may make to the pagerduty API at once, above the rate limit.")
 	flag.Parse()
 
 	fmt.Println("Setting up logger")
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	if len(envErrors) > 0 {
+		for _, err := range envErrors {
+			setupLog.Error(err, "Invalid environment variable")
+		}
+		os.Exit(1)
+	}
 	if pagerdutyAPIKey == "" {
 		setupLog.Info("API key is required.")
 		os.Exit(1)
 	}
-	if rulesetID == "" {
-		setupLog.Info("Ruleset ID is required")
+	resync, err := time.ParseDuration(resyncInterval)
+	if err != nil || resync < 0 {
+		setupLog.Info("Invalid resync interval", "resyncInterval", resyncInterval)
+		os.Exit(1)
+	}
+	cleanup, err := time.ParseDuration(cleanupTimeout)
+	if err != nil || cleanup < 0 {
+		setupLog.Info("Invalid cleanup timeout", "cleanupTimeout", cleanupTimeout)
+		os.Exit(1)
+	}
+	gc, err := time.ParseDuration(gcInterval)
+	if err != nil || gc < 0 {
+		setupLog.Info("Invalid garbage collection interval", "gcInterval", gcInterval)
+		os.Exit(1)
+	}
+	if clusterID == "" {
+		clusterID = clusterName
+	}
+	if gc > 0 && clusterID == "" {
+		setupLog.Info("The garbage collector needs -cluster-id, to tell this cluster's services from others'")
+		os.Exit(1)
+	}
+	if apiRateLimit <= 0 || apiBurst < 1 {
+		setupLog.Info("The API rate limit and burst must be positive", "apiRateLimit", apiRateLimit, "apiBurst", apiBurst)
+		os.Exit(1)
+	}
+	nameTemplate, err := controllers.ParseServiceNameTemplate(serviceNameTemplate)
+	if err != nil {
+		setupLog.Error(err, "Invalid service name template")
+		os.Exit(1)
+	}
+	if renameRate < 0 {
+		setupLog.Info("The service rename rate can't be negative", "serviceRenameRate", renameRate)
+		os.Exit(1)
+	}
+	if !controllers.IsKnownDeletionPolicy(corev1.DeletionPolicy(deletionPolicy)) {
+		setupLog.Info("Unknown deletion policy", "deletionPolicy", deletionPolicy)
+		os.Exit(1)
+	}
+	if !controllers.IsKnownOpenIncidentsPolicy(corev1.OpenIncidentsPolicy(openIncidentsPolicy)) {
+		setupLog.Info("Unknown open incidents policy", "openIncidentsPolicy", openIncidentsPolicy)
+		os.Exit(1)
+	}
+	if corev1.OpenIncidentsPolicy(openIncidentsPolicy) == corev1.OpenIncidentsResolve && fromEmail == "" {
+		setupLog.Info("Resolving open incidents needs -from-email")
+		os.Exit(1)
+	}
+	if !controllers.IsKnownSourceProfile(corev1.SourceProfile(sourceProfile)) {
+		setupLog.Info("Unknown source profile", "sourceProfile", sourceProfile)
 		os.Exit(1)
 	}
 
@@ -87,8 +194,29 @@ func main() {
 	}
 
 	setupLog.Info("Creating pagerduty client")
-	pdClient := pagerduty.NewClient(pagerdutyAPIKey)
-	_ = getRulesetOrDie(pdClient, rulesetID)
+	pdClient := pdhelpers.NewRateLimitedClient(pagerdutyAPIKey, pdhelpers.RateLimitOptions{
+		RequestsPerSecond: apiRateLimit,
+		Burst:             apiBurst,
+	})
+	if rulesetID != "" {
+		_ = getRulesetOrDie(pdClient, rulesetID)
+	} else {
+		setupLog.Info("No default ruleset given. PagerdutyServices will need a rulesetRef.")
+	}
+
+	var renames *controllers.RenameMigration
+	if renameRate > 0 {
+		setupLog.Info("Spreading out service renames", "perMinute", renameRate)
+		renames = controllers.NewRenameMigration(renameRate)
+	}
+
+	// uncached, so the operator doesn't watch every ConfigMap in the cluster
+	ledgerClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+	if err != nil {
+		setupLog.Error(err, "unable to create the orphan ledger client")
+		os.Exit(1)
+	}
+	orphans := &controllers.OrphanLedger{Client: ledgerClient, Namespace: orphanLedgerNamespace, Name: orphanLedger}
 
 	setupLog.Info("Starting reconcilers")
 	if err = (&controllers.PagerdutyServiceReconciler{
@@ -98,6 +226,19 @@ func main() {
 		PdClient:      pdClient,
 		RulesetID:     rulesetID,
 		ServicePrefix: servicePrefix,
+
+		ServiceNameTemplate:        nameTemplate,
+		ClusterName:                clusterName,
+		ClusterID:                  clusterID,
+		Renames:                    renames,
+		DefaultDeletionPolicy:      corev1.DeletionPolicy(deletionPolicy),
+		DefaultOpenIncidentsPolicy: corev1.OpenIncidentsPolicy(openIncidentsPolicy),
+		FromEmail:                  fromEmail,
+		CleanupTimeout:             cleanup,
+		Orphans:                    orphans,
+		DefaultSourceProfile:       corev1.SourceProfile(sourceProfile),
+		ResyncInterval:             resync,
+		EventRecorder:              mgr.GetEventRecorderFor("service-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PagerdutyService")
 		os.Exit(1)
@@ -108,10 +249,26 @@ func main() {
 		Log:             ctrl.Log.WithName("controllers").WithName("PagerdutyRuleset"),
 		Scheme:          mgr.GetScheme(),
 		EventRecorder:   mgr.GetEventRecorderFor("ruleset-controller"),
+		CleanupTimeout:  cleanup,
+		Orphans:         orphans,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PagerdutyRuleset")
 		os.Exit(1)
 	}
+	if gc > 0 {
+		if err = mgr.Add(&controllers.GarbageCollector{
+			Client:    mgr.GetClient(),
+			PdClient:  pdClient,
+			ClusterID: clusterID,
+			RulesetID: rulesetID,
+			Interval:  gc,
+			DryRun:    gcDryRun,
+			Orphans:   orphans,
+		}); err != nil {
+			setupLog.Error(err, "unable to add the garbage collector")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -128,7 +285,37 @@ func getEnv(key string, defaultVal string) string {
 	return defaultVal
 }
 
-func getRulesetOrDie(pdClient *pagerduty.Client, rulesetID string) *pagerduty.Ruleset {
+func getEnvFloat(key string, defaultVal float64) float64 {
+	if val, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.ParseFloat(val, 64); err == nil {
+			return parsed
+		}
+		envErrors = append(envErrors, fmt.Errorf("%s is not a valid number: %q", key, val))
+	}
+	return defaultVal
+}
+
+func getEnvInt(key string, defaultVal int) int {
+	if val, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			return parsed
+		}
+		envErrors = append(envErrors, fmt.Errorf("%s is not a valid number: %q", key, val))
+	}
+	return defaultVal
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if val, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.ParseBool(val); err == nil {
+			return parsed
+		}
+		envErrors = append(envErrors, fmt.Errorf("%s is not a boolean: %q", key, val))
+	}
+	return defaultVal
+}
+
+func getRulesetOrDie(pdClient pdhelpers.RulesetClient, rulesetID string) *pagerduty.Ruleset {
 	ruleset, _, err := pdClient.GetRuleset(rulesetID)
 	if err != nil {
 		setupLog.Error(err, fmt.Sprintf("Ruleset %s does not exist", rulesetID))
@@ -0,0 +1,120 @@
+package pdhelpers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrorClass is the kind of failure behind a pagerduty API error
+type ErrorClass string
+
+const (
+	ErrorUnknown      ErrorClass = "Unknown"
+	ErrorNotFound     ErrorClass = "NotFound"
+	ErrorRateLimited  ErrorClass = "RateLimited"
+	ErrorUnauthorized ErrorClass = "Unauthorized"
+	ErrorValidation   ErrorClass = "InvalidRequest"
+	ErrorServer       ErrorClass = "ServerError"
+	ErrorNetwork      ErrorClass = "NetworkError"
+)
+
+// The pagerduty client only returns formatted errors, e.g.
+// "Failed call API endpoint. HTTP response code: 404. Error: ...", so the status code is read from the message.
+var statusCodePattern = regexp.MustCompile(`HTTP response code: (\d{3})`)
+
+// the client's prefix for requests that never got a response
+const networkErrorPrefix = "Error calling the API endpoint"
+
+// APIError is a pagerduty API error along with its classification
+type APIError struct {
+	Class      ErrorClass
+	StatusCode int
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+// Classify works out what kind of failure err is. The HTTP response is used when the
+// client returned one, otherwise the status code is read from the error message.
+func Classify(resp *http.Response, err error) *APIError {
+	if err == nil {
+		return nil
+	}
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr
+	}
+
+	statusCode := StatusCode(err)
+	if resp != nil && resp.StatusCode >= 400 {
+		statusCode = resp.StatusCode
+	}
+
+	apiErr := &APIError{Class: ErrorUnknown, StatusCode: statusCode, Err: err}
+	switch {
+	case statusCode == http.StatusNotFound:
+		apiErr.Class = ErrorNotFound
+	case statusCode == http.StatusTooManyRequests:
+		apiErr.Class = ErrorRateLimited
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		apiErr.Class = ErrorUnauthorized
+	case statusCode >= 500:
+		apiErr.Class = ErrorServer
+	case statusCode >= 400:
+		apiErr.Class = ErrorValidation
+	case strings.HasPrefix(err.Error(), networkErrorPrefix):
+		apiErr.Class = ErrorNetwork
+	}
+	return apiErr
+}
+
+// StatusCode returns the HTTP status code of a pagerduty API error, or 0 if it didn't come from an API response
+func StatusCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.StatusCode
+	}
+	match := statusCodePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	code, _ := strconv.Atoi(match[1])
+	return code
+}
+
+// ErrorClassOf returns the class of err, which is ErrorUnknown for nil
+func ErrorClassOf(err error) ErrorClass {
+	if err == nil {
+		return ErrorUnknown
+	}
+	return Classify(nil, err).Class
+}
+
+// IsNotFound reports whether the pagerduty API said the object doesn't exist
+func IsNotFound(err error) bool {
+	return err != nil && ErrorClassOf(err) == ErrorNotFound
+}
+
+// IsPermanent reports whether retrying the same request is pointless until something
+// changes, like the API key or the resource's spec
+func IsPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+	class := ErrorClassOf(err)
+	return class == ErrorUnauthorized || class == ErrorValidation
+}
+
+// IsNameTaken reports whether pagerduty refused to create an object because its name is in use
+func IsNameTaken(err error) bool {
+	return ErrorClassOf(err) == ErrorValidation && strings.Contains(strings.ToLower(err.Error()), "already been taken")
+}
+
+// errFakeNotFound is returned by the fake clients, worded like the real client's errors
+var errFakeNotFound = fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: &{2100 Not Found []}")
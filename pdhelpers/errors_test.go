@@ -0,0 +1,58 @@
+package pdhelpers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestIsNotFound(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(IsNotFound(fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: &{2100 Not Found []}"))).To(BeTrue())
+	g.Expect(IsNotFound(fmt.Errorf("Failed call API endpoint. HTTP response code: 400. Error: &{2001 Invalid Input []}"))).To(BeFalse())
+	g.Expect(IsNotFound(fmt.Errorf("Error calling the API endpoint: dial tcp: i/o timeout"))).To(BeFalse())
+	g.Expect(IsNotFound(nil)).To(BeFalse())
+
+	g.Expect(StatusCode(fmt.Errorf("Response did not contain formatted error: EOF. HTTP response code: 502. Raw response: ..."))).To(Equal(502))
+}
+
+func TestClassify(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	cases := map[string]ErrorClass{
+		"Failed call API endpoint. HTTP response code: 429. Error: &{2020 Rate Limit Exceeded []}": ErrorRateLimited,
+		"Failed call API endpoint. HTTP response code: 401. Error: &{2006 Unauthorized []}":        ErrorUnauthorized,
+		"Failed call API endpoint. HTTP response code: 403. Error: &{2010 Forbidden []}":           ErrorUnauthorized,
+		"Failed call API endpoint. HTTP response code: 400. Error: &{2001 Invalid Input []}":       ErrorValidation,
+		"Failed call API endpoint. HTTP response code: 503. Error: &{0 Service Unavailable []}":    ErrorServer,
+		"Error calling the API endpoint: dial tcp: i/o timeout":                                    ErrorNetwork,
+		"something else went wrong": ErrorUnknown,
+	}
+	for msg, class := range cases {
+		apiErr := Classify(nil, fmt.Errorf(msg))
+		g.Expect(apiErr.Class).To(Equal(class), msg)
+		g.Expect(apiErr.Error()).To(Equal(msg))
+	}
+	g.Expect(Classify(nil, nil)).To(BeNil())
+
+	// the HTTP response wins over the message
+	apiErr := Classify(&http.Response{StatusCode: http.StatusNotFound}, fmt.Errorf("Not Found"))
+	g.Expect(apiErr.Class).To(Equal(ErrorNotFound))
+	g.Expect(IsNotFound(apiErr)).To(BeTrue())
+	g.Expect(StatusCode(apiErr)).To(Equal(404))
+
+	g.Expect(IsPermanent(fmt.Errorf("Failed call API endpoint. HTTP response code: 400. Error: nope"))).To(BeTrue())
+	g.Expect(IsPermanent(fmt.Errorf("Failed call API endpoint. HTTP response code: 500. Error: nope"))).To(BeFalse())
+	g.Expect(IsPermanent(nil)).To(BeFalse())
+}
+
+func TestIsNameTaken(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(IsNameTaken(fmt.Errorf("Failed call API endpoint. HTTP response code: 400. Error: &{2001 Invalid Input Provided [Name has already been taken.]}"))).To(BeTrue())
+	g.Expect(IsNameTaken(fmt.Errorf("Failed call API endpoint. HTTP response code: 400. Error: &{2001 Invalid Input Provided [Escalation policy is invalid.]}"))).To(BeFalse())
+	g.Expect(IsNameTaken(nil)).To(BeFalse())
+}
@@ -0,0 +1,54 @@
+package pdhelpers
+
+import (
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+// the statuses of incidents that haven't been resolved
+var openIncidentStatuses = []string{"triggered", "acknowledged"}
+
+type IncidentHelper struct {
+	IncidentClient
+}
+
+// ListOpenIncidents returns the triggered and acknowledged incidents of a service
+func (ih *IncidentHelper) ListOpenIncidents(serviceID string) ([]pagerduty.Incident, error) {
+	incidents := make([]pagerduty.Incident, 0)
+	opts := pagerduty.ListIncidentsOptions{
+		ServiceIDs: []string{serviceID},
+		Statuses:   openIncidentStatuses,
+	}
+	for {
+		resp, err := ih.ListIncidents(opts)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, resp.Incidents...)
+		if !resp.More || len(resp.Incidents) == 0 {
+			return incidents, nil
+		}
+		opts.Offset += uint(len(resp.Incidents))
+	}
+}
+
+// ResolveIncidents adds a note to each incident explaining why, and resolves them.
+// from is the email address of the pagerduty user the changes are made as.
+func (ih *IncidentHelper) ResolveIncidents(from string, incidents []pagerduty.Incident, note string) error {
+	if len(incidents) == 0 {
+		return nil
+	}
+	updates := make([]pagerduty.ManageIncidentsOptions, 0, len(incidents))
+	for _, incident := range incidents {
+		// the client sends the note's user summary as the From header
+		_, err := ih.CreateIncidentNoteWithResponse(incident.ID, pagerduty.IncidentNote{
+			User:    pagerduty.APIObject{Summary: from},
+			Content: note,
+		})
+		if err != nil {
+			return err
+		}
+		updates = append(updates, pagerduty.ManageIncidentsOptions{ID: incident.ID, Type: "incident_reference", Status: "resolved"})
+	}
+	_, err := ih.ManageIncidents(from, updates)
+	return err
+}
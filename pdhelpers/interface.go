@@ -9,9 +9,11 @@ import (
 // PagerdutyInterface allows us to write a fake client for testing
 type PagerdutyClientInterface interface {
 	EscalationPolicyClient
+	PriorityClient
 	RulesetClient
 	RulesetRuleClient
 	ServiceClient
+	IncidentClient
 }
 
 var pdci PagerdutyClientInterface = (*pagerduty.Client)(nil)
@@ -39,6 +41,13 @@ type RulesetClient interface {
 
 var _ RulesetClient = (*pagerduty.Client)(nil) // Ensure published client matches this interface.
 
+// RulesetManagerClient is what's needed to manage a ruleset along with its catch-all rule
+type RulesetManagerClient interface {
+	RulesetClient
+	RulesetRuleClient
+	ServiceClient
+}
+
 type RulesetRuleClient interface {
 	CreateRulesetRule(ruleID string, rule *pagerduty.RulesetRule) (*pagerduty.RulesetRule, *http.Response, error)
 	DeleteRulesetRule(ruleID string, rulesetID string) error
@@ -54,3 +63,17 @@ type EscalationPolicyClient interface {
 }
 
 var _ EscalationPolicyClient = (*pagerduty.Client)(nil)
+
+type PriorityClient interface {
+	ListPriorities() (*pagerduty.Priorities, error)
+}
+
+var _ PriorityClient = (*pagerduty.Client)(nil)
+
+type IncidentClient interface {
+	ListIncidents(o pagerduty.ListIncidentsOptions) (*pagerduty.ListIncidentsResponse, error)
+	ManageIncidents(from string, incidents []pagerduty.ManageIncidentsOptions) (*pagerduty.ListIncidentsResponse, error)
+	CreateIncidentNoteWithResponse(id string, note pagerduty.IncidentNote) (*pagerduty.IncidentNote, error)
+}
+
+var _ IncidentClient = (*pagerduty.Client)(nil)
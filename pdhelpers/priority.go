@@ -0,0 +1,24 @@
+package pdhelpers
+
+import (
+	"fmt"
+)
+
+type PriorityHelper struct {
+	PriorityClient
+}
+
+// GetPriorityIDByName looks up the ID of a priority, e.g. "P1", by its name
+func (ph *PriorityHelper) GetPriorityIDByName(name string) (string, error) {
+	resp, err := ph.ListPriorities()
+	if err != nil {
+		return "", err
+	}
+
+	for _, priority := range resp.Priorities {
+		if priority.Name == name {
+			return priority.ID, nil
+		}
+	}
+	return "", fmt.Errorf("No priority found with name \"%s\"", name)
+}
@@ -0,0 +1,243 @@
+package pdhelpers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// how often a throttled or failed request is retried before giving up
+	defaultMaxRetries = 5
+	// first delay of the exponential backoff for server errors
+	defaultBaseBackoff = 500 * time.Millisecond
+	// longest backoff between retries, and longest Retry-After we honor
+	defaultMaxBackoff = 30 * time.Second
+)
+
+var (
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pagerduty_api_requests_total",
+		Help: "Requests made to the pagerduty API, by response code",
+	}, []string{"code"})
+	apiRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pagerduty_api_retries_total",
+		Help: "Requests to the pagerduty API that were retried, by reason",
+	}, []string{"reason"})
+	apiThrottleSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pagerduty_api_throttled_seconds_total",
+		Help: "Time spent waiting for the pagerduty API rate limit",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(apiRequests, apiRetries, apiThrottleSeconds)
+}
+
+// RateLimitOptions configures the requests made by NewRateLimitedClient
+type RateLimitOptions struct {
+	// RequestsPerSecond is the sustained rate of requests shared by all reconcilers
+	RequestsPerSecond float64
+	// Burst is how many requests can be made at once
+	Burst int
+}
+
+// NewRateLimitedClient creates a pagerduty client whose requests share a single rate limit,
+// and are retried when throttled or when pagerduty has a server error
+func NewRateLimitedClient(authToken string, opts RateLimitOptions) PagerdutyClientInterface {
+	client := pagerduty.NewClient(authToken)
+	client.HTTPClient = NewRateLimitedHTTPClient(client.HTTPClient, opts)
+	return client
+}
+
+// RateLimitedHTTPClient throttles the requests of a pagerduty.HTTPClient. A 429 response
+// pauses all requests for as long as its Retry-After header asks, and server errors
+// are retried with exponential backoff and jitter. POSTs aren't retried on server errors,
+// since pagerduty may have created the object before a gateway failed the request.
+type RateLimitedHTTPClient struct {
+	Inner   pagerduty.HTTPClient
+	Limiter *rate.Limiter
+
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// sleep is swapped out in tests
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mutex        sync.Mutex
+	blockedUntil time.Time
+}
+
+var _ pagerduty.HTTPClient = &RateLimitedHTTPClient{}
+
+func NewRateLimitedHTTPClient(inner pagerduty.HTTPClient, opts RateLimitOptions) *RateLimitedHTTPClient {
+	return &RateLimitedHTTPClient{
+		Inner:       inner,
+		Limiter:     rate.NewLimiter(rate.Limit(opts.RequestsPerSecond), opts.Burst),
+		MaxRetries:  defaultMaxRetries,
+		BaseBackoff: defaultBaseBackoff,
+		MaxBackoff:  defaultMaxBackoff,
+		sleep:       sleepContext,
+	}
+}
+
+// Do sends the request once the rate limit allows it, retrying throttled requests and server errors
+func (c *RateLimitedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if err := c.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.Inner.Do(req)
+		if err != nil {
+			return resp, err
+		}
+		apiRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+
+		var delay time.Duration
+		var reason string
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			reason = "rate_limited"
+			delay = retryAfter(resp)
+			if delay == 0 {
+				delay = c.backoff(attempt)
+			}
+			if delay > c.MaxBackoff {
+				delay = c.MaxBackoff
+			}
+			// everyone shares the API key's limit, so everyone waits
+			c.blockFor(delay)
+		case resp.StatusCode >= 500 && idempotent(req):
+			reason = "server_error"
+			delay = c.backoff(attempt)
+		default:
+			return resp, nil
+		}
+
+		if attempt >= c.MaxRetries || !rewind(req) {
+			return resp, nil
+		}
+		discard(resp)
+		apiRetries.WithLabelValues(reason).Inc()
+		if reason == "server_error" {
+			if err = c.sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// wait blocks until the client isn't paused by a 429 and the limiter has a token
+func (c *RateLimitedHTTPClient) wait(ctx context.Context) error {
+	c.mutex.Lock()
+	pause := time.Until(c.blockedUntil)
+	c.mutex.Unlock()
+
+	start := time.Now()
+	if pause > 0 {
+		if err := c.sleep(ctx, pause); err != nil {
+			return err
+		}
+	}
+	if err := c.Limiter.Wait(ctx); err != nil {
+		return err
+	}
+	if waited := time.Since(start); waited > 0 {
+		apiThrottleSeconds.Add(waited.Seconds())
+	}
+	return nil
+}
+
+func (c *RateLimitedHTTPClient) blockFor(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if until := time.Now().Add(d); until.After(c.blockedUntil) {
+		c.blockedUntil = until
+	}
+}
+
+// backoff is an exponential backoff with full jitter
+func (c *RateLimitedHTTPClient) backoff(attempt int) time.Duration {
+	max := c.BaseBackoff << uint(attempt)
+	if max <= 0 || max > c.MaxBackoff {
+		max = c.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(max)) + 1)
+}
+
+// retryAfter reads how long pagerduty wants us to wait, from either Retry-After or its ratelimit-reset header
+func retryAfter(resp *http.Response) time.Duration {
+	for _, header := range []string{"Retry-After", "Ratelimit-Reset"} {
+		value := resp.Header.Get(header)
+		if value == "" {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			if d := time.Until(date); d > 0 {
+				return d
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// idempotent reports whether a request can be sent again after a server error without doing twice what it does
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// rewind resets the request body so it can be sent again, reporting whether that's possible
+func rewind(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+	return true
+}
+
+// discard drains and closes a response we won't hand back, so the connection can be reused
+func discard(resp *http.Response) {
+	if resp.Body != nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+package pdhelpers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/time/rate"
+)
+
+// fakeHTTPClient answers with the given status codes in turn, recording the request bodies
+type fakeHTTPClient struct {
+	responses []*http.Response
+	bodies    []string
+}
+
+func (f *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		body, _ := ioutil.ReadAll(req.Body)
+		f.bodies = append(f.bodies, string(body))
+	}
+	resp := f.responses[0]
+	if len(f.responses) > 1 {
+		f.responses = f.responses[1:]
+	}
+	return resp, nil
+}
+
+func response(statusCode int, headers map[string]string) *http.Response {
+	resp := &http.Response{StatusCode: statusCode, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewBufferString("{}"))}
+	for key, value := range headers {
+		resp.Header.Set(key, value)
+	}
+	return resp
+}
+
+func newTestRateLimitedClient(inner *fakeHTTPClient) (*RateLimitedHTTPClient, *[]time.Duration) {
+	slept := make([]time.Duration, 0)
+	c := NewRateLimitedHTTPClient(inner, RateLimitOptions{RequestsPerSecond: float64(rate.Inf), Burst: 1})
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	return c, &slept
+}
+
+func TestRetryAfter(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	inner := &fakeHTTPClient{responses: []*http.Response{
+		response(http.StatusTooManyRequests, map[string]string{"Retry-After": "2"}),
+		response(http.StatusOK, nil),
+	}}
+	c, slept := newTestRateLimitedClient(inner)
+
+	req, _ := http.NewRequest("POST", "https://api.pagerduty.com/services", bytes.NewBufferString(`{"service":{}}`))
+	resp, err := c.Do(req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	// the retry waited about as long as asked, and sent the same body again
+	g.Expect(*slept).To(HaveLen(1))
+	g.Expect((*slept)[0]).To(BeNumerically("~", 2*time.Second, 100*time.Millisecond))
+	g.Expect(inner.bodies).To(Equal([]string{`{"service":{}}`, `{"service":{}}`}))
+
+	g.Expect(retryAfter(response(http.StatusTooManyRequests, map[string]string{"Ratelimit-Reset": "7"}))).To(Equal(7 * time.Second))
+	g.Expect(retryAfter(response(http.StatusTooManyRequests, nil))).To(BeZero())
+}
+
+func TestServerErrorBackoff(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	inner := &fakeHTTPClient{responses: []*http.Response{
+		response(http.StatusBadGateway, nil),
+		response(http.StatusServiceUnavailable, nil),
+		response(http.StatusOK, nil),
+	}}
+	c, slept := newTestRateLimitedClient(inner)
+
+	req, _ := http.NewRequest("GET", "https://api.pagerduty.com/services/ABC", nil)
+	resp, err := c.Do(req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	g.Expect(*slept).To(HaveLen(2))
+	g.Expect((*slept)[0]).To(BeNumerically("<=", defaultBaseBackoff))
+	g.Expect((*slept)[1]).To(BeNumerically("<=", 2*defaultBaseBackoff))
+
+	// a server that keeps failing is given up on, and its last response handed back
+	inner = &fakeHTTPClient{responses: []*http.Response{response(http.StatusInternalServerError, nil)}}
+	c, slept = newTestRateLimitedClient(inner)
+	resp, err = c.Do(req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+	g.Expect(*slept).To(HaveLen(defaultMaxRetries))
+
+	// client errors aren't retried
+	inner = &fakeHTTPClient{responses: []*http.Response{response(http.StatusBadRequest, nil)}}
+	c, slept = newTestRateLimitedClient(inner)
+	resp, err = c.Do(req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	g.Expect(*slept).To(BeEmpty())
+}
+
+// TestServerErrorNotRetriedForPost ensures a create that may have gone through isn't sent twice
+func TestServerErrorNotRetriedForPost(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	inner := &fakeHTTPClient{responses: []*http.Response{
+		response(http.StatusServiceUnavailable, nil),
+		response(http.StatusOK, nil),
+	}}
+	c, slept := newTestRateLimitedClient(inner)
+
+	req, _ := http.NewRequest("POST", "https://api.pagerduty.com/services", bytes.NewBufferString(`{"service":{}}`))
+	resp, err := c.Do(req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+	g.Expect(inner.bodies).To(HaveLen(1))
+	g.Expect(*slept).To(BeEmpty())
+}
@@ -0,0 +1,117 @@
+package pdhelpers
+
+import (
+	"sort"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+// RuleMove is a single position update on a ruleset rule
+type RuleMove struct {
+	RuleID   string
+	Position int
+}
+
+// PlanRuleMoves works out how to reorder the managed rules of a ruleset so that rules with a
+// higher priority are evaluated first. Managed rules are the ones that have an entry in priorities.
+// They are shuffled between the positions they already occupy, so unmanaged rules keep their
+// positions and the catch-all rule stays last. Managed rules with equal priorities keep
+// their relative order.
+//
+// Moving a rule to a position shifts the rules in between, like removing it from the list
+// and inserting it at the new index. Only managed rules are ever moved.
+func PlanRuleMoves(rules []*pagerduty.RulesetRule, priorities map[string]int) []RuleMove {
+	current := orderedRuleIDs(rules)
+	desired := desiredRuleOrder(current, priorities)
+
+	moves := make([]RuleMove, 0)
+	for {
+		idx := firstDifference(current, desired)
+		if idx < 0 {
+			return moves
+		}
+
+		var ruleID string
+		var position int
+		if _, managed := priorities[desired[idx]]; managed {
+			// pull the rule that belongs here up from further down
+			ruleID, position = desired[idx], idx
+		} else {
+			// an unmanaged rule belongs here. We don't touch unmanaged rules,
+			// so move the managed rule that's in the way to just below it instead.
+			ruleID, position = current[idx], indexOf(current, desired[idx])
+		}
+		current = moveRule(current, ruleID, position)
+		moves = append(moves, RuleMove{RuleID: ruleID, Position: position})
+	}
+}
+
+// orderedRuleIDs lists the rules by position, leaving out the catch-all rule
+func orderedRuleIDs(rules []*pagerduty.RulesetRule) []string {
+	sorted := make([]*pagerduty.RulesetRule, 0, len(rules))
+	for _, rule := range rules {
+		if !rule.CatchAll {
+			sorted = append(sorted, rule)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rulePosition(sorted[i]) < rulePosition(sorted[j])
+	})
+
+	ruleIDs := make([]string, len(sorted))
+	for i, rule := range sorted {
+		ruleIDs[i] = rule.ID
+	}
+	return ruleIDs
+}
+
+func rulePosition(rule *pagerduty.RulesetRule) int {
+	if rule.Position == nil {
+		return int(^uint(0) >> 1)
+	}
+	return *rule.Position
+}
+
+func desiredRuleOrder(current []string, priorities map[string]int) []string {
+	slots := make([]int, 0)
+	managed := make([]string, 0)
+	for idx, ruleID := range current {
+		if _, ok := priorities[ruleID]; ok {
+			slots = append(slots, idx)
+			managed = append(managed, ruleID)
+		}
+	}
+	sort.SliceStable(managed, func(i, j int) bool {
+		return priorities[managed[i]] > priorities[managed[j]]
+	})
+
+	desired := append([]string{}, current...)
+	for i, slot := range slots {
+		desired[slot] = managed[i]
+	}
+	return desired
+}
+
+func firstDifference(a []string, b []string) int {
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return idx
+		}
+	}
+	return -1
+}
+
+func indexOf(slice []string, value string) int {
+	for idx, item := range slice {
+		if item == value {
+			return idx
+		}
+	}
+	return -1
+}
+
+func moveRule(ruleIDs []string, ruleID string, position int) []string {
+	idx := indexOf(ruleIDs, ruleID)
+	moved := append(append([]string{}, ruleIDs[:idx]...), ruleIDs[idx+1:]...)
+	return append(moved[:position], append([]string{ruleID}, moved[position:]...)...)
+}
@@ -0,0 +1,62 @@
+package pdhelpers
+
+import (
+	"testing"
+
+	"github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+)
+
+func TestPlanRuleMoves(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	position := func(p int) *int { return &p }
+	// returned out of order, to make sure we go by position
+	rules := []*pagerduty.RulesetRule{
+		{ID: "generic", Position: position(1)},
+		{ID: "unmanaged-a", Position: position(0)},
+		{ID: "catchall", CatchAll: true},
+		{ID: "unmanaged-b", Position: position(2)},
+		{ID: "medium", Position: position(3)},
+		{ID: "specific", Position: position(4)},
+		{ID: "unmanaged-c", Position: position(5)},
+	}
+	priorities := map[string]int{
+		"generic":  0,
+		"medium":   5,
+		"specific": 10,
+	}
+
+	moves := PlanRuleMoves(rules, priorities)
+
+	order := orderedRuleIDs(rules)
+	for _, move := range moves {
+		_, managed := priorities[move.RuleID]
+		g.Expect(managed).To(BeTrue(), "moved unmanaged rule %s", move.RuleID)
+		order = moveRule(order, move.RuleID, move.Position)
+	}
+	g.Expect(order).To(Equal([]string{"unmanaged-a", "specific", "unmanaged-b", "medium", "generic", "unmanaged-c"}))
+
+	// Once ordered, nothing else needs to move
+	for idx, ruleID := range order {
+		for _, rule := range rules {
+			if rule.ID == ruleID {
+				rule.Position = position(idx)
+			}
+		}
+	}
+	g.Expect(PlanRuleMoves(rules, priorities)).To(BeEmpty())
+}
+
+func TestPlanRuleMovesKeepsTies(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	position := func(p int) *int { return &p }
+	rules := []*pagerduty.RulesetRule{
+		{ID: "a", Position: position(0)},
+		{ID: "b", Position: position(1)},
+		{ID: "c", Position: position(2)},
+	}
+	g.Expect(PlanRuleMoves(rules, map[string]int{"a": 1, "b": 1, "c": 1})).To(BeEmpty())
+	g.Expect(PlanRuleMoves(rules, map[string]int{"a": 1, "c": 1})).To(BeEmpty())
+}
@@ -9,6 +9,7 @@ import (
 
 type RulesetHelper struct {
 	RulesetClient
+	RulesetRuleClient
 }
 
 // AdoptOrCreateRuleset either fetches or create a ruleset matching the supplied options
@@ -62,26 +63,49 @@ func (rsh *RulesetHelper) GetRulesetByName(name string) (*pagerduty.Ruleset, err
 
 }
 
-func (rsh *RulesetHelper) addCatchallRule(ruleset *pagerduty.Ruleset, targetServiceName string) error {
+// SetCatchallRoute points the ruleset's catch-all rule at the given service, creating the rule if needed.
+// Pagerduty doesn't let us delete a catch-all rule, so an empty serviceID restores its
+// default action of suppressing unmatched events instead.
+func (rsh *RulesetHelper) SetCatchallRoute(rulesetID string, serviceID string) (*pagerduty.RulesetRule, error) {
+	rule, err := rsh.GetCatchallRule(rulesetID)
+	if err != nil {
+		return nil, err
+	}
 
-	// service, err := GetServiceByName(client, targetServiceName)
-	// if err != nil {
-	// 	return err
-	// }
+	actions := &pagerduty.RuleActions{}
+	if serviceID != "" {
+		actions.Route = &pagerduty.RuleActionParameter{Value: serviceID}
+	} else {
+		actions.Suppress = &pagerduty.RuleActionSuppress{Value: true}
+	}
 
-	// _, _, err = client.CreateRulesetRule(
-	// 	ruleset.ID,
-	// 	&pagerduty.RulesetRule{
-	// 		CatchAll: true,
-	// 		Actions: &pagerduty.RuleActions{
-	// 			Route: &pagerduty.RuleActionParameter{
-	// 				Value: service.ID,
-	// 			},
-	// 		},
-	// 	},
-	// )
-	// return err
-	return nil
+	if rule == nil {
+		rule, _, err = rsh.CreateRulesetRule(rulesetID, &pagerduty.RulesetRule{
+			CatchAll: true,
+			Actions:  actions,
+		})
+	} else {
+		rule.Actions = actions
+		rule, _, err = rsh.UpdateRulesetRule(rulesetID, rule.ID, rule)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// GetCatchallRule returns the ruleset's catch-all rule, or nil if it doesn't have one
+func (rsh *RulesetHelper) GetCatchallRule(rulesetID string) (*pagerduty.RulesetRule, error) {
+	resp, err := rsh.ListRulesetRules(rulesetID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range resp.Rules {
+		if rule.CatchAll {
+			return rule, nil
+		}
+	}
+	return nil, nil
 }
 
 /***
@@ -89,10 +113,15 @@ func (rsh *RulesetHelper) addCatchallRule(ruleset *pagerduty.Ruleset, targetServ
 ***/
 type FakeRulesetClient struct {
 	RulesetsByID map[string]*pagerduty.Ruleset
+	// Rules are stored by ruleset ID, then rule ID
+	Rules map[string]map[string]*pagerduty.RulesetRule
 }
 
 func NewFakeRulesetClient() FakeRulesetClient {
-	rsc := FakeRulesetClient{RulesetsByID: make(map[string]*pagerduty.Ruleset)}
+	rsc := FakeRulesetClient{
+		RulesetsByID: make(map[string]*pagerduty.Ruleset),
+		Rules:        make(map[string]map[string]*pagerduty.RulesetRule),
+	}
 	return rsc
 }
 
@@ -106,6 +135,13 @@ func (rsc FakeRulesetClient) CreateRuleset(r *pagerduty.Ruleset) (*pagerduty.Rul
 		r.ID = RandomString(10)
 	}
 	rsc.RulesetsByID[r.ID] = r
+	// like the real thing, new rulesets come with a catch-all rule that suppresses everything
+	catchall := &pagerduty.RulesetRule{
+		ID:       RandomString(10),
+		CatchAll: true,
+		Actions:  &pagerduty.RuleActions{Suppress: &pagerduty.RuleActionSuppress{Value: true}},
+	}
+	rsc.Rules[r.ID] = map[string]*pagerduty.RulesetRule{catchall.ID: catchall}
 	return r, &http.Response{StatusCode: http.StatusCreated}, nil
 }
 
@@ -113,8 +149,9 @@ func (rsc FakeRulesetClient) DeleteRuleset(id string) error {
 	var err error = nil
 	if _, ok := rsc.RulesetsByID[id]; ok {
 		delete(rsc.RulesetsByID, id)
+		delete(rsc.Rules, id)
 	} else {
-		err = fmt.Errorf("Not Found")
+		err = errFakeNotFound
 	}
 	return err
 }
@@ -127,7 +164,7 @@ func (rsc FakeRulesetClient) GetRuleset(id string) (*pagerduty.Ruleset, *http.Re
 		statusCode = http.StatusOK
 	} else {
 		statusCode = http.StatusNotFound
-		err = fmt.Errorf("Not Found")
+		err = errFakeNotFound
 	}
 	return rs, &http.Response{StatusCode: statusCode}, err
 }
@@ -149,3 +186,51 @@ func (rsc FakeRulesetClient) UpdateRuleset(r *pagerduty.Ruleset) (*pagerduty.Rul
 	rsc.RulesetsByID[r.ID] = r
 	return r, &http.Response{StatusCode: http.StatusOK}, nil
 }
+
+func (rsc FakeRulesetClient) CreateRulesetRule(rulesetID string, rule *pagerduty.RulesetRule) (*pagerduty.RulesetRule, *http.Response, error) {
+	rules, ok := rsc.Rules[rulesetID]
+	if !ok {
+		return nil, &http.Response{StatusCode: http.StatusNotFound}, errFakeNotFound
+	}
+	if rule.ID == "" {
+		rule.ID = RandomString(10)
+	}
+	rules[rule.ID] = rule
+	return rule, &http.Response{StatusCode: http.StatusCreated}, nil
+}
+
+func (rsc FakeRulesetClient) DeleteRulesetRule(rulesetID string, ruleID string) error {
+	if _, ok := rsc.Rules[rulesetID][ruleID]; !ok {
+		return errFakeNotFound
+	}
+	delete(rsc.Rules[rulesetID], ruleID)
+	return nil
+}
+
+func (rsc FakeRulesetClient) GetRulesetRule(rulesetID string, ruleID string) (*pagerduty.RulesetRule, *http.Response, error) {
+	rule, ok := rsc.Rules[rulesetID][ruleID]
+	if !ok {
+		return nil, &http.Response{StatusCode: http.StatusNotFound}, errFakeNotFound
+	}
+	return rule, &http.Response{StatusCode: http.StatusOK}, nil
+}
+
+func (rsc FakeRulesetClient) ListRulesetRules(rulesetID string) (*pagerduty.ListRulesetRulesResponse, error) {
+	rules, ok := rsc.Rules[rulesetID]
+	if !ok {
+		return nil, errFakeNotFound
+	}
+	resp := pagerduty.ListRulesetRulesResponse{Total: uint(len(rules))}
+	for _, rule := range rules {
+		resp.Rules = append(resp.Rules, rule)
+	}
+	return &resp, nil
+}
+
+func (rsc FakeRulesetClient) UpdateRulesetRule(rulesetID string, ruleID string, rule *pagerduty.RulesetRule) (*pagerduty.RulesetRule, *http.Response, error) {
+	if _, ok := rsc.Rules[rulesetID][ruleID]; !ok {
+		return nil, &http.Response{StatusCode: http.StatusNotFound}, errFakeNotFound
+	}
+	rsc.Rules[rulesetID][ruleID] = rule
+	return rule, &http.Response{StatusCode: http.StatusOK}, nil
+}
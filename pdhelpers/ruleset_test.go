@@ -30,3 +30,34 @@ func TestRulesetHelpers(t *testing.T) {
 	g.Expect(len(fakeClient.RulesetsByID)).To(Equal(1))
 
 }
+
+func TestSetCatchallRoute(t *testing.T) {
+	g := NewGomegaWithT(t)
+	fakeClient := NewFakeRulesetClient()
+	rsHelper := RulesetHelper{RulesetClient: fakeClient, RulesetRuleClient: fakeClient}
+
+	rs, _, err := rsHelper.AdoptOrCreateRuleset("catchall")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// New rulesets come with a catch-all rule, which gets routed rather than duplicated
+	rule, err := rsHelper.SetCatchallRoute(rs.ID, "SVC1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(rule.CatchAll).To(BeTrue())
+	g.Expect(rule.Actions.Route.Value).To(Equal("SVC1"))
+	g.Expect(rule.Actions.Suppress).To(BeNil())
+	g.Expect(fakeClient.Rules[rs.ID]).To(HaveLen(1))
+
+	// Unsetting the service goes back to suppressing events
+	rule2, err := rsHelper.SetCatchallRoute(rs.ID, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(rule2.ID).To(Equal(rule.ID))
+	g.Expect(rule2.Actions.Route).To(BeNil())
+	g.Expect(rule2.Actions.Suppress.Value).To(BeTrue())
+
+	// and a missing catch-all rule is created
+	delete(fakeClient.Rules[rs.ID], rule.ID)
+	rule3, err := rsHelper.SetCatchallRoute(rs.ID, "SVC2")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(rule3.CatchAll).To(BeTrue())
+	g.Expect(fakeClient.Rules[rs.ID]).To(HaveLen(1))
+}
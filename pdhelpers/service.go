@@ -2,6 +2,7 @@ package pdhelpers
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/PagerDuty/go-pagerduty"
 )
@@ -10,27 +11,120 @@ type ServiceHelper struct {
 	ServiceClient
 }
 
+// GetServiceByName returns the service with exactly the given name, failing if there isn't one
 func (sh *ServiceHelper) GetServiceByName(name string) (*pagerduty.Service, error) {
-	resp, err := sh.ListServices(pagerduty.ListServiceOptions{
-		Query: name, // underdocumented, but this appears to do a substring match on the name field
-	})
-
+	service, err := sh.FindServiceByName(name)
 	if err != nil {
 		return nil, err
 	}
+	if service == nil {
+		return nil, fmt.Errorf("No service found with name \"%s\"", name)
+	}
+	return service, nil
+}
 
+// FindServiceByName returns the service with exactly the given name, or nil if there is none
+func (sh *ServiceHelper) FindServiceByName(name string) (*pagerduty.Service, error) {
 	matches := make([]pagerduty.Service, 0, 4)
-	for _, svc := range resp.Services {
-		if svc.Name == name {
-			matches = append(matches, svc)
+	opts := pagerduty.ListServiceOptions{
+		Query: name, // underdocumented, but this appears to do a substring match on the name field
+	}
+	for {
+		resp, err := sh.ListServices(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range resp.Services {
+			if svc.Name == name {
+				matches = append(matches, svc)
+			}
 		}
+		if !resp.More || len(resp.Services) == 0 {
+			break
+		}
+		opts.Offset += uint(len(resp.Services))
 	}
 
 	if len(matches) == 0 {
-		return nil, fmt.Errorf("No service found with name \"%s\"", name)
+		return nil, nil
 	} else if len(matches) > 1 {
 		return nil, fmt.Errorf("Too many services with name \"%s\" (found %d)", name, len(matches))
-	} else {
-		return &matches[0], nil
 	}
+	return &matches[0], nil
+}
+
+// ListAllServices returns every service in the account, going through all the pages
+func (sh *ServiceHelper) ListAllServices() ([]pagerduty.Service, error) {
+	return sh.SearchServices("")
+}
+
+// SearchServices returns the services whose names contain query, going through all the pages
+func (sh *ServiceHelper) SearchServices(query string) ([]pagerduty.Service, error) {
+	services := make([]pagerduty.Service, 0)
+	opts := pagerduty.ListServiceOptions{Query: query}
+	for {
+		resp, err := sh.ListServices(opts)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, resp.Services...)
+		if !resp.More || len(resp.Services) == 0 {
+			break
+		}
+		opts.Offset += uint(len(resp.Services))
+	}
+	return services, nil
+}
+
+/***
+* FakeServiceClient, for testing
+***/
+type FakeServiceClient struct {
+	ServicesByID map[string]*pagerduty.Service
+}
+
+func NewFakeServiceClient() FakeServiceClient {
+	return FakeServiceClient{ServicesByID: make(map[string]*pagerduty.Service)}
+}
+
+func (sc FakeServiceClient) CreateService(service pagerduty.Service) (*pagerduty.Service, error) {
+	if service.ID == "" {
+		service.ID = RandomString(10)
+	}
+	sc.ServicesByID[service.ID] = &service
+	return &service, nil
+}
+
+func (sc FakeServiceClient) DeleteService(id string) error {
+	if _, ok := sc.ServicesByID[id]; !ok {
+		return errFakeNotFound
+	}
+	delete(sc.ServicesByID, id)
+	return nil
+}
+
+func (sc FakeServiceClient) GetService(id string, opts *pagerduty.GetServiceOptions) (*pagerduty.Service, error) {
+	service, ok := sc.ServicesByID[id]
+	if !ok {
+		return nil, errFakeNotFound
+	}
+	return service, nil
+}
+
+func (sc FakeServiceClient) ListServices(o pagerduty.ListServiceOptions) (*pagerduty.ListServiceResponse, error) {
+	resp := pagerduty.ListServiceResponse{}
+	for _, service := range sc.ServicesByID {
+		if strings.Contains(service.Name, o.Query) {
+			resp.Services = append(resp.Services, *service)
+		}
+	}
+	return &resp, nil
+}
+
+func (sc FakeServiceClient) UpdateService(service pagerduty.Service) (*pagerduty.Service, error) {
+	if _, ok := sc.ServicesByID[service.ID]; !ok {
+		return nil, errFakeNotFound
+	}
+	sc.ServicesByID[service.ID] = &service
+	return &service, nil
 }
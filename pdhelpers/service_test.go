@@ -0,0 +1,32 @@
+package pdhelpers
+
+import (
+	"testing"
+
+	"github.com/PagerDuty/go-pagerduty"
+	. "github.com/onsi/gomega"
+)
+
+func TestFindServiceByName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	fakeClient := NewFakeServiceClient()
+	helper := ServiceHelper{ServiceClient: fakeClient}
+
+	// Nothing to find isn't an error, unless the service is required
+	svc, err := helper.FindServiceByName("api")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(svc).To(BeNil())
+	_, err = helper.GetServiceByName("api")
+	g.Expect(err).To(HaveOccurred())
+
+	// Only exact names match
+	_, _ = fakeClient.CreateService(pagerduty.Service{Name: "api"})
+	_, _ = fakeClient.CreateService(pagerduty.Service{Name: "api-internal"})
+	svc, err = helper.FindServiceByName("api")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(svc.Name).To(Equal("api"))
+
+	_, _ = fakeClient.CreateService(pagerduty.Service{Name: "api"})
+	_, err = helper.FindServiceByName("api")
+	g.Expect(err).To(HaveOccurred())
+}